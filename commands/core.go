@@ -1,6 +1,8 @@
 package commands
 
 import (
+	"errors"
+	"fmt"
 	"os"
 
 	"github.com/ecnahc515/core/coreos"
@@ -18,9 +20,16 @@ var (
 		},
 	}
 
-	logLevel string
+	logLevel    string
+	releaseURLs []string
+	retryPolicy = coreos.DefaultRetryPolicy
+	distrosFile string
+	// provider is the release provider chosen with --distro
+	provider coreos.ReleaseProvider
 )
 
+const defaultDistrosFile = "$HOME/.core/distros.json"
+
 func Execute() {
 	AddCommands()
 	CoreCmd.Execute()
@@ -29,11 +38,24 @@ func Execute() {
 func AddCommands() {
 	CoreCmd.AddCommand(RunCmd)
 	CoreCmd.AddCommand(FetchCmd)
+	CoreCmd.AddCommand(KeysCmd)
+	CoreCmd.AddCommand(ReleasesCmd)
+	CoreCmd.AddCommand(ImportCmd)
+	CoreCmd.AddCommand(ImagesCmd)
+	CoreCmd.AddCommand(ValidateCmd)
 }
 
 func init() {
 	CoreCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "level of logging information by package (pkg=level)")
 	CoreCmd.PersistentFlags().StringVar(&coreCfg.ImageDirectory, "image-dir", coreos.DefaultImageDirectory, "Directory of where images are located")
+	CoreCmd.PersistentFlags().StringVar(&coreCfg.Distro, "distro", coreos.DefaultProvider.Name(), "Distribution to use, coreos, flatcar or one from the distros file")
+	CoreCmd.PersistentFlags().StringVar(&distrosFile, "distros-file", defaultDistrosFile, "JSON file describing custom distributions")
+	CoreCmd.PersistentFlags().StringSliceVar(&releaseURLs, "release-url", nil, "Release URL templates to download from instead of the distribution's, tried in order. {channel} is replaced by the channel and {board} by the board")
+	CoreCmd.PersistentFlags().IntVar(&retryPolicy.Attempts, "retries", coreos.DefaultRetryPolicy.Attempts, "Number of times to try each release server")
+	CoreCmd.PersistentFlags().DurationVar(&retryPolicy.InitialBackoff, "retry-backoff", coreos.DefaultRetryPolicy.InitialBackoff, "Time to wait before the first retry, doubling for each retry after")
+	CoreCmd.PersistentFlags().DurationVar(&retryPolicy.MaxBackoff, "retry-max-backoff", coreos.DefaultRetryPolicy.MaxBackoff, "Longest time to wait between retries")
+	CoreCmd.PersistentFlags().DurationVar(&coreos.LockTimeout, "lock-timeout", coreos.LockTimeout, "Time to wait for other core processes using the image directory, such as another fetch of the same image")
+	CoreCmd.PersistentFlags().DurationVar(&retryPolicy.Timeout, "timeout", coreos.DefaultRetryPolicy.Timeout, "Time to wait for a release server to respond before retrying, 0 to wait forever")
 }
 
 func InitializeConfig() {
@@ -50,6 +72,24 @@ func InitializeConfig() {
 		plog.Printf("Setting log level to %s", logLevel)
 	}
 
+	if _, err := coreos.LoadDistros(os.ExpandEnv(distrosFile)); err != nil {
+		plog.Fatalf("Unable to load custom distributions. err: %v", err)
+	}
+	var err error
+	provider, err = coreos.LookupProvider(coreCfg.Distro)
+	if err != nil {
+		plog.Fatal(err)
+	}
+
+	if err := coreos.Mirrors(releaseURLs).Validate(); err != nil {
+		plog.Fatal(err)
+	}
+	if coreCfg.Board != "" {
+		if err := coreos.ValidateBoard(coreCfg.Board); err != nil {
+			plog.Fatal(err)
+		}
+	}
+
 	// TODO move to fetch/run specifically?
 	if coreCfg.ImageDirectory == coreos.DefaultImageDirectory {
 		coreCfg.ImageDirectory = os.ExpandEnv(coreCfg.ImageDirectory)
@@ -60,6 +100,49 @@ func InitializeConfig() {
 	}
 }
 
+// newClient returns a client for the release servers configured by flags.
+func newClient() *coreos.Client {
+	return &coreos.Client{
+		Provider: provider,
+		Mirrors:  releaseURLs,
+		Retry:    retryPolicy,
+		Board:    coreCfg.Board,
+	}
+}
+
+// newKeyring returns the keyring of the release provider in the image
+// directory, trusting the keys built into the provider.
+func newKeyring() *coreos.Keyring {
+	keyring := coreos.NewKeyring(coreCfg.ImageDirectory)
+	keyring.Provider = provider
+	return keyring
+}
+
+// explainReleaseError adds a suggestion of what to do about an error from the
+// release servers.
+func explainReleaseError(err error) string {
+	var hint string
+	switch {
+	case errors.Is(err, coreos.ErrChannelNotFound):
+		hint = "Check the channel name, it should be alpha, beta or stable."
+	case errors.Is(err, coreos.ErrVersionNotFound):
+		hint = "Check that the version was released in that channel with `core releases`, or leave it out to fetch the current version."
+	case errors.Is(err, coreos.ErrNoTrustedKeys):
+		hint = fmt.Sprintf("%s has no built in signing key, add one with `core keys add --distro=%s`.", provider.Name(), provider.Name())
+	case errors.Is(err, coreos.ErrBoardNotServed):
+		hint = "Give a --release-url containing {board} to download other boards."
+	case errors.Is(err, coreos.ErrImageInUse):
+		hint = "Stop the VM using it first."
+	case errors.Is(err, coreos.ErrLockTimeout):
+		hint = "Another core process is using the image directory. Try again once it's finished, or wait longer with --lock-timeout."
+	case errors.Is(err, coreos.ErrServer):
+		hint = "The release server is having problems. Try again later, or use a mirror with --release-url."
+	default:
+		return err.Error()
+	}
+	return fmt.Sprintf("%v. %s", err, hint)
+}
+
 func CreateDirIfNotExist(dir string) error {
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		return os.MkdirAll(dir, 0700)
@@ -1,8 +1,10 @@
 package commands
 
 import (
+	"context"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/ecnahc515/core/coreos"
 	"github.com/spf13/cobra"
@@ -13,54 +15,100 @@ const defaultChannel = "alpha"
 var FetchCmd = &cobra.Command{
 	Use:   "fetch [channel] [version]",
 	Short: "Download a CoreOS image",
-	Long:  "Downloads a CoreOS image from release.core-os.net, storing it locally. Defaults to alpha channel if unspecified.",
+	Long: `Downloads a CoreOS image from release.core-os.net, or the mirrors given by
+--release-url, storing it locally. Images of other distributions, such as
+Flatcar, are downloaded with --distro. Defaults to the current alpha release if
+unspecified.
+
+The release can also be given as a single version specifier, such as stable,
+beta@latest, alpha@1010.x, >=1000.0.0 or stable~1 for the previous stable
+release. Versions are resolved against the releases listed by the release
+server.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		fetchImage(cmd, args)
 	},
 }
 
+var fetchParallelism int
+
+func init() {
+	FetchCmd.Flags().IntVar(&fetchParallelism, "parallel", coreos.DefaultParallelism, "Number of files to download at once")
+	FetchCmd.Flags().StringVar(&coreCfg.Board, "board", coreos.DefaultBoard, "CoreOS image board, amd64-usr or arm64-usr")
+}
+
 func fetchImage(cmd *cobra.Command, args []string) {
 	InitializeConfig()
-	channel := defaultChannel
-	if len(args) > 1 {
-		channel = args[0]
+	if len(args) > 2 {
+		cmd.Usage()
+		os.Exit(1)
 	}
-	var (
-		version string
-		err     error
-	)
-	if len(args) == 2 {
-		version = args[1]
-	} else {
-		version, err = coreos.GetVersionID(defaultChannel)
-		if err != nil {
-			plog.Fatalf("Unable to get version for channel %s. err: %v", channel, err)
-		}
+	spec, err := parseVersionArgs(args)
+	if err != nil {
+		plog.Fatalf("%v", err)
+	}
+	channel := spec.Channel
+
+	// Stop looking up the version or cancel the download when we get a
+	// signal, Download returns once everything is closed and cleaned up
+	ctx, cancel := signalContext(os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	version, err := newClient().ResolveVersion(ctx, spec)
+	if err != nil {
+		plog.Fatalf("Unable to find %s %s (%s). err: %s", provider.Name(), channel, spec.Query(), explainReleaseError(err))
 	}
 	plog.Debugf("Channel: %s, Version: %s\n", channel, version)
 
 	downloader := coreos.NewDownloader(channel, version, coreCfg.ImageDirectory)
+	downloader.Board = coreCfg.Board
+	downloader.Provider = provider
+	downloader.Keyring = newKeyring()
+	downloader.Client = newClient()
+	downloader.Parallelism = fetchParallelism
+	downloader.Progress = newProgressFunc()
 
-	// Setup signal handlers so we properly cleanup when we get a signal
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, os.Kill)
-	go func() {
-		<-sigChan
-		downloader.Stop()
-		return
-	}()
-
-	err = downloader.Download(coreos.Vmlinuz)
+	// partial downloads are left in place so running fetch again resumes them
+	err = downloader.Download(ctx, provider.Kernel(), provider.Initrd())
 	if err != nil {
-		downloader.Cleanup()
-		plog.Fatalf("Error downloading %s to %s. err: %v", coreos.Vmlinuz, coreCfg.ImageDirectory, err)
+		plog.Fatalf("Error downloading %s %s (%s) to %s. err: %s", provider.Name(), channel, version, coreCfg.ImageDirectory, explainReleaseError(err))
 		return
 	}
-	err = downloader.Download(coreos.Initrd)
-	if err != nil {
-		downloader.Cleanup()
-		plog.Fatalf("Error downloading %s to %s. err: %v", coreos.Vmlinuz, coreCfg.ImageDirectory, err)
-		return
+	plog.Infof("Successfully downloaded %s %s (%s)", provider.Name(), channel, version)
+}
+
+// parseVersionArgs turns "[channel] [version]" arguments into a version
+// specifier. A single argument is a specifier on its own.
+func parseVersionArgs(args []string) (coreos.VersionSpec, error) {
+	var s string
+	switch len(args) {
+	case 1:
+		s = args[0]
+	case 2:
+		s = args[0] + "@" + args[1]
+	}
+	spec, err := coreos.ParseVersionSpec(s)
+	if spec.Channel == "" {
+		spec.Channel = defaultChannel
+	}
+	return spec, err
+}
+
+// signalContext returns a context that is canceled when any of sigs is
+// received. Calling the returned func stops listening for them.
+func signalContext(sigs ...os.Signal) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, sigs...)
+	go func() {
+		select {
+		case sig := <-sigChan:
+			plog.Infof("Received %s, stopping", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sigChan)
+		cancel()
 	}
-	plog.Infof("Successfully downloaded CoreOS %s (%s)", channel, version)
 }
@@ -0,0 +1,582 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ecnahc515/core/coreos"
+	"github.com/spf13/cobra"
+)
+
+var ImagesCmd = &cobra.Command{
+	Use:   "images",
+	Short: "Manage local images",
+	Long:  "List, inspect and remove the images downloaded by core fetch or added by core import.",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var imagesListCmd = &cobra.Command{
+	Use:     "ls",
+	Aliases: []string{"list"},
+	Short:   "List local images",
+	Run: func(cmd *cobra.Command, args []string) {
+		listImages(cmd, args)
+	},
+}
+
+var imagesRemoveCmd = &cobra.Command{
+	Use:     "rm <spec>...",
+	Aliases: []string{"remove"},
+	Short:   "Remove local images",
+	Long: `Removes the local image each version specifier selects, such as
+stable@1010.5.0, alpha~2 or beta@<1000. With --all every image matching a
+specifier is removed instead of only the newest, so alpha@1010.x --all removes
+every 1010 alpha release. Specifiers without a channel are for alpha.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		removeImages(cmd, args)
+	},
+}
+
+var imagesPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove old local images",
+	Long: `Removes local images selected by any of the given policies: all but the
+newest --keep versions of each channel, those not used for longer than
+--older-than, and the least recently used until the rest fit in --max-size.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pruneImages(cmd, args)
+	},
+}
+
+var imagesInspectCmd = &cobra.Command{
+	Use:   "inspect <spec>",
+	Short: "Show what a local image boots",
+	Long: `Shows the kernel version and boot header of the image a version specifier
+selects, and the files in its initrd along with the os-release inside it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		inspectImage(cmd, args)
+	},
+}
+
+var imagesVerifyCmd = &cobra.Command{
+	Use:   "verify [<spec>...]",
+	Short: "Check local images for corruption or tampering",
+	Long: `Re-checks the files of the images the version specifiers select, or of every
+local image, against the digests recorded when they were added and their
+detached signatures. Images that fail are moved to the .quarantine directory
+of the image directory, so they're no longer booted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		verifyImages(cmd, args)
+	},
+}
+
+var imagesAddCmd = &cobra.Command{
+	Use:   "add <name> --kernel <vmlinuz> --initrd <initrd>",
+	Short: "Add a locally built kernel and initrd as a custom image",
+	Long: `Copies a locally built kernel and initrd into the local images as a custom
+image called name, which core run --image boots like an image of --distro.
+The kernel has to be a bzImage and the initrd a gzip compressed cpio archive.
+Custom images can be given to the other images commands by name, and are never
+pruned.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		addCustomImage(cmd, args)
+	},
+}
+
+var imagesExportCmd = &cobra.Command{
+	Use:   "export (<spec>... | --all) --file <bundle>",
+	Short: "Write local images to a bundle",
+	Long: `Writes the images the version specifiers select, or every local image with
+--all, into a tar archive along with their signatures, digests and index
+records, for carrying to machines that can't reach a release server. The
+archive is gzip compressed if its name ends in .gz or .tgz, or with --gzip.
+Import it with core images import-bundle.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		exportImages(cmd, args)
+	},
+}
+
+var imagesImportBundleCmd = &cobra.Command{
+	Use:   "import-bundle <bundle>",
+	Short: "Add the images in a bundle to the local images",
+	Long: `Adds the images in a bundle written by core images export. Every file is
+checked against the digests in the bundle, and every signature against the
+keyring, before any image is added. If adding an image fails after that, the
+images already added are kept and listed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		importBundle(cmd, args)
+	},
+}
+
+var (
+	imagesOutput    string
+	imagesRemoveAll bool
+	pruneKeep       int
+	pruneOlderThan  string
+	pruneMaxSize    string
+	pruneDryRun     bool
+	quarantine      bool
+	exportAll       bool
+	exportFile      string
+	exportGzip      bool
+	bundleForce     bool
+	customKernel    string
+	customInitrd    string
+	customForce     bool
+)
+
+func init() {
+	imagesListCmd.Flags().StringVarP(&imagesOutput, "output", "o", "table", "Output format, table or json")
+	imagesRemoveCmd.Flags().BoolVar(&imagesRemoveAll, "all", false, "Remove every image matching each specifier")
+	imagesRemoveCmd.Flags().StringVar(&coreCfg.Board, "board", coreos.DefaultBoard, "CoreOS image board, amd64-usr or arm64-usr")
+	imagesInspectCmd.Flags().StringVarP(&imagesOutput, "output", "o", "table", "Output format, table or json")
+	imagesInspectCmd.Flags().StringVar(&coreCfg.Board, "board", coreos.DefaultBoard, "CoreOS image board, amd64-usr or arm64-usr")
+	imagesVerifyCmd.Flags().StringVarP(&imagesOutput, "output", "o", "table", "Output format, table or json")
+	imagesVerifyCmd.Flags().StringVar(&coreCfg.Board, "board", coreos.DefaultBoard, "CoreOS image board, amd64-usr or arm64-usr")
+	imagesVerifyCmd.Flags().BoolVar(&quarantine, "quarantine", true, "Move the files of images that fail out of the image directory")
+	imagesExportCmd.Flags().BoolVar(&exportAll, "all", false, "Export every local image")
+	imagesExportCmd.Flags().StringVarP(&exportFile, "file", "f", "", "File to write the bundle to, - for stdout")
+	imagesExportCmd.Flags().BoolVar(&exportGzip, "gzip", false, "Compress the bundle with gzip")
+	imagesExportCmd.Flags().StringVar(&coreCfg.Board, "board", coreos.DefaultBoard, "CoreOS image board, amd64-usr or arm64-usr")
+	imagesAddCmd.Flags().StringVar(&customKernel, "kernel", "", "Path to the kernel")
+	imagesAddCmd.Flags().StringVar(&customInitrd, "initrd", "", "Path to the initrd")
+	imagesAddCmd.Flags().BoolVar(&customForce, "force", false, "Replace the custom image if it already exists")
+	imagesAddCmd.Flags().StringVar(&coreCfg.Board, "board", coreos.DefaultBoard, "Board the kernel is built for, amd64-usr or arm64-usr")
+	imagesImportBundleCmd.Flags().BoolVar(&bundleForce, "force", false, "Replace images that already exist")
+	imagesPruneCmd.Flags().IntVar(&pruneKeep, "keep", 0, "Number of versions of each channel to keep, 0 to keep them all")
+	imagesPruneCmd.Flags().StringVar(&pruneOlderThan, "older-than", "", "Remove images not used for this long, such as 720h or 30d")
+	imagesPruneCmd.Flags().StringVar(&pruneMaxSize, "max-size", "", "Remove the least recently used images until the rest fit in this size, such as 2G")
+	imagesPruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "List the images that would be removed without removing them")
+
+	ImagesCmd.AddCommand(imagesListCmd)
+	ImagesCmd.AddCommand(imagesRemoveCmd)
+	ImagesCmd.AddCommand(imagesPruneCmd)
+	ImagesCmd.AddCommand(imagesInspectCmd)
+	ImagesCmd.AddCommand(imagesVerifyCmd)
+	ImagesCmd.AddCommand(imagesExportCmd)
+	ImagesCmd.AddCommand(imagesAddCmd)
+	ImagesCmd.AddCommand(imagesImportBundleCmd)
+}
+
+func openStore() *coreos.Store {
+	store, err := coreos.OpenStore(coreCfg.ImageDirectory)
+	if err != nil {
+		plog.Fatalf("Unable to open image store. err: %v", err)
+	}
+	return store
+}
+
+// imageListing is one image in the output of core images ls.
+type imageListing struct {
+	Distro   string              `json:"distro"`
+	Channel  string              `json:"channel,omitempty"`
+	Version  string              `json:"version,omitempty"`
+	Name     string              `json:"name,omitempty"`
+	Board    string              `json:"board"`
+	Size     int64               `json:"size"`
+	Verified coreos.Verification `json:"verified"`
+	Source   string              `json:"source"`
+	Fetched  time.Time           `json:"fetched"`
+	LastUsed *time.Time          `json:"last_used,omitempty"`
+}
+
+func listImages(cmd *cobra.Command, args []string) {
+	InitializeConfig()
+	if len(args) != 0 || (imagesOutput != "table" && imagesOutput != "json") {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	images := openStore().Images()
+	listings := make([]imageListing, len(images))
+	for i, img := range images {
+		listings[i] = imageListing{
+			Distro:   img.Distro,
+			Channel:  img.Channel,
+			Version:  img.Version,
+			Name:     img.Name,
+			Board:    img.Board,
+			Size:     img.Size(),
+			Verified: img.Verified(),
+			Source:   img.Source,
+			Fetched:  img.Fetched,
+			LastUsed: img.LastUsed,
+		}
+	}
+	if imagesOutput == "json" {
+		printJSON(listings)
+		return
+	}
+	printImages(images)
+}
+
+func printImages(images []coreos.Image) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "DISTRO\tCHANNEL\tVERSION\tBOARD\tSIZE\tVERIFIED\tLAST USED")
+	for _, img := range images {
+		channel, version := img.Channel, img.Version
+		if img.IsCustom() {
+			channel, version = coreos.CustomChannel, img.Name
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", img.Distro, channel, version, img.Board,
+			formatBytes(img.Size()), img.Verified(), formatLastUsed(img.LastUsed))
+	}
+	w.Flush()
+}
+
+func formatLastUsed(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func removeImages(cmd *cobra.Command, args []string) {
+	InitializeConfig()
+	if len(args) == 0 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	store := openStore()
+	var images []coreos.Image
+	for _, arg := range args {
+		if !imagesRemoveAll {
+			images = append(images, findImage(store, arg))
+			continue
+		}
+		spec, err := parseVersionArgs([]string{arg})
+		if err != nil {
+			plog.Fatalf("%v", err)
+		}
+		found := false
+		for _, version := range store.Versions(provider, spec.Channel, coreCfg.Board) {
+			if spec.Matches(version) {
+				img, _ := store.Get(provider, spec.Channel, coreCfg.Board, version)
+				images = append(images, img)
+				found = true
+			}
+		}
+		if !found {
+			plog.Fatalf("No local image matches %s", arg)
+		}
+	}
+	for _, img := range images {
+		if err := store.Remove(img); err != nil {
+			plog.Fatalf("Unable to remove %s. err: %v", img, err)
+		}
+		plog.Infof("Removed %s", img)
+	}
+}
+
+func pruneImages(cmd *cobra.Command, args []string) {
+	InitializeConfig()
+	if len(args) != 0 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	policy := coreos.PrunePolicy{KeepLast: pruneKeep}
+	var err error
+	if pruneOlderThan != "" {
+		if policy.OlderThan, err = parseAge(pruneOlderThan); err != nil {
+			plog.Fatalf("Invalid --older-than. err: %v", err)
+		}
+	}
+	if pruneMaxSize != "" {
+		if policy.MaxSize, err = parseSize(pruneMaxSize); err != nil {
+			plog.Fatalf("Invalid --max-size. err: %v", err)
+		}
+	}
+	if policy == (coreos.PrunePolicy{}) {
+		plog.Fatalf("Nothing to prune by, use --keep, --older-than or --max-size")
+	}
+
+	store := openStore()
+	images := store.Prunable(policy, time.Now())
+	if len(images) == 0 {
+		plog.Infof("No images to prune")
+		return
+	}
+	if pruneDryRun {
+		printImages(images)
+		return
+	}
+	var freed int64
+	pruned := 0
+	for _, img := range images {
+		err := store.Remove(img)
+		if errors.Is(err, coreos.ErrImageInUse) {
+			// a VM started using it since it was selected
+			plog.Warningf("Skipping %s, it is in use", img)
+			continue
+		}
+		if err != nil {
+			plog.Fatalf("Unable to remove %s. err: %v", img, err)
+		}
+		plog.Infof("Removed %s", img)
+		freed += img.Size()
+		pruned++
+	}
+	plog.Infof("Pruned %d images, freeing %s", pruned, formatBytes(freed))
+}
+
+func inspectImage(cmd *cobra.Command, args []string) {
+	InitializeConfig()
+	if len(args) != 1 || (imagesOutput != "table" && imagesOutput != "json") {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	store := openStore()
+	img := findImage(store, args[0])
+	useImage(store, img)
+	in, err := coreos.Inspect(coreCfg)
+	if err != nil {
+		plog.Fatalf("Unable to inspect %s. err: %v", img, err)
+	}
+	if imagesOutput == "json" {
+		printJSON(in)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Image:\t%s\n", img)
+	fmt.Fprintf(w, "Kernel:\t%s\n", in.Vmlinuz)
+	fmt.Fprintf(w, "  Version:\t%s\n", in.Kernel.Version)
+	fmt.Fprintf(w, "  Boot protocol:\t%s\n", in.Kernel.Protocol)
+	fmt.Fprintf(w, "  Setup size:\t%s\n", formatBytes(in.Kernel.SetupSize))
+	fmt.Fprintf(w, "  Kernel size:\t%s\n", formatBytes(in.Kernel.KernelSize))
+	if in.Kernel.PayloadSize != 0 {
+		fmt.Fprintf(w, "  Payload size:\t%s\n", formatBytes(in.Kernel.PayloadSize))
+	}
+	if in.Kernel.InitSize != 0 {
+		fmt.Fprintf(w, "  Init size:\t%s\n", formatBytes(in.Kernel.InitSize))
+	}
+	fmt.Fprintf(w, "  Max cmdline:\t%d\n", in.Kernel.CmdlineSize)
+	fmt.Fprintf(w, "  Relocatable:\t%t\n", in.Kernel.Relocatable)
+	fmt.Fprintf(w, "Initrd:\t%s\n", in.Initrd)
+	fmt.Fprintf(w, "  Size:\t%s\n", formatBytes(in.Image.Size))
+	fmt.Fprintf(w, "  Unpacked size:\t%s\n", formatBytes(in.Image.UnpackedSize))
+	fmt.Fprintf(w, "  Files:\t%d\n", len(in.Image.Files))
+	if in.Image.OSRelease == nil {
+		fmt.Fprintf(w, "  OS release:\tnone\n")
+	} else {
+		var keys []string
+		for k := range in.Image.OSRelease {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(w, "  OS release:\n")
+		for _, k := range keys {
+			fmt.Fprintf(w, "    %s:\t%s\n", k, in.Image.OSRelease[k])
+		}
+	}
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "MODE\tSIZE\tNAME")
+	for _, f := range in.Image.Files {
+		name := f.Name
+		if f.Link != "" {
+			name += " -> " + f.Link
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.Mode, formatBytes(f.Size), name)
+	}
+	w.Flush()
+}
+
+func verifyImages(cmd *cobra.Command, args []string) {
+	InitializeConfig()
+	if imagesOutput != "table" && imagesOutput != "json" {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	store := openStore()
+	images := store.Images()
+	if len(args) > 0 {
+		images = nil
+		for _, arg := range args {
+			images = append(images, findImage(store, arg))
+		}
+	}
+
+	keyring := newKeyring()
+	reports := []coreos.VerifyReport{}
+	failed := 0
+	for _, img := range images {
+		// images of other distributions are checked with their own keys
+		p, err := coreos.LookupProvider(img.Distro)
+		if err != nil {
+			plog.Fatalf("Unable to verify %s. err: %v", img, err)
+		}
+		keyring.Provider = p
+		report, err := store.Verify(img, keyring)
+		if err != nil {
+			plog.Fatalf("Unable to verify %s. err: %v", img, err)
+		}
+		if report.Failed() {
+			failed++
+			if quarantine {
+				if report.Quarantined, err = store.Quarantine(img); err != nil {
+					plog.Errorf("Unable to quarantine %s. err: %v", img, err)
+				}
+			}
+		}
+		reports = append(reports, report)
+	}
+
+	if imagesOutput == "json" {
+		printJSON(reports)
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "IMAGE\tFILE\tSTATUS\tDETAIL")
+		for _, report := range reports {
+			for _, f := range report.Files {
+				detail := f.Error
+				if detail == "" && f.Signature {
+					detail = "digests and signature match"
+				} else if detail == "" {
+					detail = "digests match"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", report.Image, f.Name, f.Status, detail)
+			}
+		}
+		w.Flush()
+		for _, report := range reports {
+			if report.Quarantined != "" {
+				plog.Warningf("Moved the files of %s to %s", report.Image, report.Quarantined)
+			}
+		}
+	}
+	if failed > 0 {
+		plog.Fatalf("%d of %d images failed verification", failed, len(reports))
+	}
+}
+
+func exportImages(cmd *cobra.Command, args []string) {
+	InitializeConfig()
+	if exportFile == "" || (len(args) == 0) == !exportAll {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	store := openStore()
+	var images []coreos.Image
+	for _, img := range store.Images() {
+		if !img.IsCustom() {
+			images = append(images, img)
+		}
+	}
+	if !exportAll {
+		images = nil
+		for _, arg := range args {
+			images = append(images, findImage(store, arg))
+		}
+	}
+	if len(images) == 0 {
+		plog.Fatalf("No local images to export")
+	}
+
+	out := os.Stdout
+	compress := exportGzip || strings.HasSuffix(exportFile, ".gz") || strings.HasSuffix(exportFile, ".tgz")
+	if exportFile != "-" {
+		f, err := os.Create(exportFile)
+		if err != nil {
+			plog.Fatalf("Unable to create bundle. err: %v", err)
+		}
+		out = f
+	}
+	err := store.Export(out, images, compress)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if exportFile != "-" {
+			os.Remove(exportFile)
+		}
+		plog.Fatalf("Unable to export images. err: %v", err)
+	}
+	for _, img := range images {
+		plog.Infof("Exported %s", img)
+	}
+}
+
+func importBundle(cmd *cobra.Command, args []string) {
+	InitializeConfig()
+	if len(args) != 1 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	images, err := openStore().ImportBundle(args[0], newKeyring(), bundleForce)
+	if err != nil {
+		if len(images) > 0 {
+			plog.Errorf("Only imported %d images from %s: %v", len(images), args[0], images)
+		}
+		plog.Fatalf("Unable to import %s. err: %v", args[0], err)
+	}
+	plog.Infof("Successfully imported %d images from %s", len(images), args[0])
+}
+
+func addCustomImage(cmd *cobra.Command, args []string) {
+	InitializeConfig()
+	if len(args) != 1 || customKernel == "" || customInitrd == "" {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	img, err := openStore().AddCustom(provider, args[0], coreCfg.Board, customKernel, customInitrd, customForce)
+	if err != nil {
+		plog.Fatalf("Unable to add custom image %s. err: %v", args[0], err)
+	}
+	plog.Infof("Added %s, boot it with `core run --image %s`", img, args[0])
+}
+
+// findImage returns the custom image called arg, or else the image of
+// --distro and --board the version specifier arg selects.
+func findImage(store *coreos.Store, arg string) coreos.Image {
+	if img, ok := store.Custom(arg); ok {
+		return img
+	}
+	spec, err := parseVersionArgs([]string{arg})
+	if err != nil {
+		plog.Fatalf("%v", err)
+	}
+	img, err := store.Find(provider, spec.Channel, coreCfg.Board, spec)
+	if err != nil {
+		plog.Fatalf("No local image matches %s. err: %v", arg, err)
+	}
+	return img
+}
+
+// parseAge parses a duration, also accepting whole days such as 30d and
+// weeks such as 2w.
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, suffix)); err == nil && strings.HasSuffix(s, suffix) {
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// parseSize parses a size in bytes with an optional binary unit, such as 512M
+// or 1.5GiB.
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	mult := int64(1)
+	if i := strings.LastIndexAny(num, "KMGT"); i >= 0 && i == len(num)-1 {
+		for n := strings.IndexByte("KMGT", num[i]); n >= 0; n-- {
+			mult *= 1024
+		}
+		num = num[:i]
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
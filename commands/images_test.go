@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAge(t *testing.T) {
+	const day = 24 * time.Hour
+	tests := []struct {
+		age  string
+		want time.Duration
+		err  bool
+	}{
+		{age: "30d", want: 30 * day},
+		{age: "0d", want: 0},
+		{age: "2w", want: 14 * day},
+		{age: "36h", want: 36 * time.Hour},
+		{age: "1h30m", want: 90 * time.Minute},
+		{age: "0", want: 0},
+
+		{age: "", err: true},
+		{age: "d", err: true},
+		{age: "w", err: true},
+		{age: "1.5d", err: true},
+		{age: "2dw", err: true},
+		{age: "30", err: true},
+		{age: "30x", err: true},
+		{age: "thirty days", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseAge(tt.age)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseAge(%q) = %v, expected an error", tt.age, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseAge(%q) = %v, %v, expected %v", tt.age, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size string
+		want int64
+		err  bool
+	}{
+		{size: "0", want: 0},
+		{size: "512", want: 512},
+		{size: "512B", want: 512},
+		{size: "1K", want: 1 << 10},
+		{size: "1k", want: 1 << 10},
+		{size: "1KB", want: 1 << 10},
+		{size: "1KiB", want: 1 << 10},
+		{size: "512M", want: 512 << 20},
+		{size: "512mib", want: 512 << 20},
+		{size: "1.5GiB", want: 3 << 29},
+		{size: "2G", want: 2 << 30},
+		{size: "1T", want: 1 << 40},
+
+		{size: "", err: true},
+		{size: "B", err: true},
+		{size: "GiB", err: true},
+		{size: "-1", err: true},
+		{size: "-1G", err: true},
+		{size: "1X", err: true},
+		{size: "1KK", err: true},
+		{size: "1 G", err: true},
+		{size: "1P", err: true},
+		{size: "one", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.size)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseSize(%q) = %d, expected an error", tt.size, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, expected %d", tt.size, got, err, tt.want)
+		}
+	}
+}
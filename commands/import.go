@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"os"
+
+	"github.com/ecnahc515/core/coreos"
+	"github.com/spf13/cobra"
+)
+
+var ImportCmd = &cobra.Command{
+	Use:   "import <channel> <version> (<vmlinuz> <initrd> | <archive>)",
+	Short: "Import a CoreOS image from local files",
+	Long: `Adds a kernel and initrd to the local images, for machines that can't reach a
+release server, so that core run can boot them. They can be given as two files
+or as a tar archive, optionally gzip compressed, holding a file ending in
+vmlinuz and one ending in cpio.gz.
+
+A detached signature (<file>.sig) or DIGESTS file (<file>.DIGESTS) next to
+either file, or alongside it in the archive, is used to verify it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		importImage(cmd, args)
+	},
+}
+
+var importForce bool
+
+func init() {
+	ImportCmd.Flags().StringVar(&coreCfg.Board, "board", coreos.DefaultBoard, "CoreOS image board, amd64-usr or arm64-usr")
+	ImportCmd.Flags().BoolVar(&importForce, "force", false, "Replace the image if it already exists")
+}
+
+func importImage(cmd *cobra.Command, args []string) {
+	InitializeConfig()
+	if len(args) != 3 && len(args) != 4 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	channel, version := args[0], args[1]
+	importer := coreos.NewImporter(channel, version, coreCfg.ImageDirectory)
+	importer.Board = coreCfg.Board
+	importer.Provider = provider
+	importer.Keyring = newKeyring()
+	importer.Force = importForce
+
+	var err error
+	if len(args) == 3 {
+		err = importer.ImportArchive(args[2])
+	} else {
+		err = importer.Import(args[2], args[3])
+	}
+	if err != nil {
+		plog.Fatalf("Unable to import %s %s (%s). err: %v", provider.Name(), channel, version, err)
+	}
+	plog.Infof("Successfully imported %s %s (%s)", provider.Name(), channel, version)
+}
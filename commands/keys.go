@@ -0,0 +1,174 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ecnahc515/core/coreos"
+	"github.com/spf13/cobra"
+)
+
+var KeysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage keys trusted to sign images",
+	Long: `Manage the keyring of keys trusted to sign downloaded images. Each
+distribution has its own keyring, chosen with --distro, so a key added for
+one can't sign another's releases. The keys built into the distribution,
+such as the CoreOS buildbot key, are always trusted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the keys trusted for a distribution",
+	Run: func(cmd *cobra.Command, args []string) {
+		listKeys()
+	},
+}
+
+var keysShowCmd = &cobra.Command{
+	Use:   "show <fingerprint>",
+	Short: "Show a trusted key and its subkeys",
+	Run: func(cmd *cobra.Command, args []string) {
+		showKey(cmd, args)
+	},
+}
+
+var keysAddCmd = &cobra.Command{
+	Use:   "add <file>",
+	Short: "Trust an armored public key",
+	Long: `Imports an armored public key from a file into the keyring of the
+distribution chosen with --distro. The key must be pinned by its full
+fingerprint using --fingerprint, which defaults to the published fingerprint
+of the distribution's key if it has one that isn't built in, as Flatcar does.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		addKey(cmd, args)
+	},
+}
+
+var keysRemoveCmd = &cobra.Command{
+	Use:   "remove <fingerprint>",
+	Short: "Stop trusting a key for a distribution",
+	Run: func(cmd *cobra.Command, args []string) {
+		removeKey(cmd, args)
+	},
+}
+
+var keyFingerprint string
+
+func init() {
+	keysAddCmd.Flags().StringVar(&keyFingerprint, "fingerprint", "", "Full fingerprint of the key to import")
+
+	KeysCmd.AddCommand(keysListCmd)
+	KeysCmd.AddCommand(keysShowCmd)
+	KeysCmd.AddCommand(keysAddCmd)
+	KeysCmd.AddCommand(keysRemoveCmd)
+}
+
+func listKeys() {
+	InitializeConfig()
+	keys, err := newKeyring().List()
+	if err != nil {
+		plog.Fatalf("Unable to read keyring. err: %v", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "FINGERPRINT\tCREATED\tEXPIRES\tIDENTITY")
+	for _, key := range keys {
+		identity := strings.Join(key.Identities, ", ")
+		if key.Builtin {
+			identity += " (built in)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key.Fingerprint, formatKeyTime(key.Created), formatKeyExpiry(key), identity)
+	}
+	w.Flush()
+}
+
+func showKey(cmd *cobra.Command, args []string) {
+	InitializeConfig()
+	if len(args) != 1 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	key, err := newKeyring().Get(args[0])
+	if err != nil {
+		plog.Fatalf("Unable to find key %s. err: %v", args[0], err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Fingerprint:\t%s\n", key.Fingerprint)
+	fmt.Fprintf(w, "Key ID:\t%s\n", key.KeyID)
+	for _, identity := range key.Identities {
+		fmt.Fprintf(w, "Identity:\t%s\n", identity)
+	}
+	fmt.Fprintf(w, "Created:\t%s\n", formatKeyTime(key.Created))
+	fmt.Fprintf(w, "Expires:\t%s\n", formatKeyExpiry(key))
+	fmt.Fprintf(w, "Built in:\t%t\n", key.Builtin)
+	w.Flush()
+
+	if len(key.Subkeys) == 0 {
+		return
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SUBKEY\tCREATED\tEXPIRES\tSIGNING")
+	for _, sub := range key.Subkeys {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", sub.KeyID, formatKeyTime(sub.Created), formatKeyExpiry(sub), sub.CanSign)
+	}
+	w.Flush()
+}
+
+func addKey(cmd *cobra.Command, args []string) {
+	InitializeConfig()
+	if len(args) != 1 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	if d, ok := provider.(*coreos.Distro); ok && keyFingerprint == "" {
+		keyFingerprint = d.KeyFingerprint
+	}
+	if keyFingerprint == "" {
+		plog.Fatalf("Refusing to import %s without a pinned fingerprint, use --fingerprint", args[0])
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		plog.Fatalf("Unable to open key file. err: %v", err)
+	}
+	defer f.Close()
+	key, err := newKeyring().Add(f, keyFingerprint)
+	if err != nil {
+		plog.Fatalf("Unable to import key from %s. err: %v", args[0], err)
+	}
+	plog.Infof("Trusting key %s (%s) for %s", key.Fingerprint, strings.Join(key.Identities, ", "), provider.Name())
+}
+
+func removeKey(cmd *cobra.Command, args []string) {
+	InitializeConfig()
+	if len(args) != 1 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	key, err := newKeyring().Remove(args[0])
+	if err != nil {
+		plog.Fatalf("Unable to remove key %s. err: %v", args[0], err)
+	}
+	plog.Infof("No longer trusting key %s for %s", key.Fingerprint, provider.Name())
+}
+
+func formatKeyTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+func formatKeyExpiry(key coreos.KeyInfo) string {
+	if key.Expires.IsZero() {
+		return "never"
+	}
+	expires := formatKeyTime(key.Expires)
+	if key.Expired(time.Now()) {
+		expires += " (expired)"
+	}
+	return expires
+}
@@ -0,0 +1,173 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/pkg/capnslog"
+	"github.com/ecnahc515/core/coreos"
+)
+
+const (
+	progressBarWidth = 30
+	// progressLogInterval is how often progress is logged when not writing
+	// to a terminal.
+	progressLogInterval = 5 * time.Second
+)
+
+// newProgressFunc returns a coreos.ProgressFunc that draws a progress bar
+// per file on stderr if it is a terminal, and logs periodically otherwise.
+// Log messages are written above the bars while they're drawn.
+func newProgressFunc() coreos.ProgressFunc {
+	if isTerminal(os.Stderr) {
+		bars := &progressBars{state: make(map[string]coreos.Progress)}
+		capnslog.SetFormatter(&progressFormatter{
+			Formatter: capnslog.NewStringFormatter(os.Stderr),
+			bars:      bars,
+		})
+		return bars.update
+	}
+	last := make(map[string]time.Time)
+	return func(p coreos.Progress) {
+		if p.Failed {
+			// why is logged where it failed
+			return
+		}
+		if !p.Complete && time.Since(last[p.File]) < progressLogInterval {
+			return
+		}
+		last[p.File] = time.Now()
+		plog.Infof("%s: %s", p.File, formatProgress(p))
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// progressBars redraws one line per file, in the order they started. Once
+// every file is complete or has failed the bars are left as they are, and any
+// later downloads get bars of their own below them.
+type progressBars struct {
+	mu    sync.Mutex
+	files []string
+	state map[string]coreos.Progress
+	drawn int
+}
+
+func (b *progressBars) update(p coreos.Progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prev, ok := b.state[p.File]
+	if p.Failed {
+		if !ok {
+			// it failed before it had a bar
+			return
+		}
+		// the bar stays where the download stopped
+		prev.Failed = true
+		p = prev
+	}
+	if !ok {
+		b.files = append(b.files, p.File)
+	}
+	b.state[p.File] = p
+	if b.drawn > 0 {
+		// move back up to the first line we drew
+		fmt.Fprintf(os.Stderr, "\033[%dA", b.drawn)
+	}
+	b.draw()
+	for _, file := range b.files {
+		if p := b.state[file]; !p.Complete && !p.Failed {
+			return
+		}
+	}
+	b.files, b.drawn = nil, 0
+	b.state = make(map[string]coreos.Progress)
+}
+
+func (b *progressBars) draw() {
+	for _, file := range b.files {
+		// \033[K clears whatever was left over from a longer previous line
+		fmt.Fprintf(os.Stderr, "\r%s\033[K\n", progressBar(b.state[file]))
+	}
+	b.drawn = len(b.files)
+}
+
+// clear erases the bars, leaving the cursor where the first one was.
+func (b *progressBars) clear() {
+	if b.drawn > 0 {
+		fmt.Fprintf(os.Stderr, "\033[%dA\r\033[J", b.drawn)
+		b.drawn = 0
+	}
+}
+
+// progressFormatter writes log messages where the progress bars are, and
+// draws the bars again below them, so messages don't end up between the bars
+// or drawn over by them.
+type progressFormatter struct {
+	capnslog.Formatter
+	bars *progressBars
+}
+
+func (f *progressFormatter) Format(pkg string, level capnslog.LogLevel, depth int, entries ...interface{}) {
+	f.bars.mu.Lock()
+	defer f.bars.mu.Unlock()
+	f.bars.clear()
+	f.Formatter.Format(pkg, level, depth+1, entries...)
+	f.bars.draw()
+}
+
+func progressBar(p coreos.Progress) string {
+	bar := strings.Repeat(" ", progressBarWidth)
+	if p.Total > 0 {
+		filled := int(int64(progressBarWidth) * p.Done / p.Total)
+		if filled > progressBarWidth {
+			filled = progressBarWidth
+		}
+		bar = strings.Repeat("=", filled) + bar[filled:]
+	}
+	return fmt.Sprintf("%s [%s] %s", p.File, bar, formatProgress(p))
+}
+
+func formatProgress(p coreos.Progress) string {
+	s := formatBytes(p.Done)
+	if p.Total >= 0 {
+		s = fmt.Sprintf("%3d%% %s/%s", p.Done*100/max64(p.Total, 1), s, formatBytes(p.Total))
+	}
+	s = fmt.Sprintf("%s %s/s", s, formatBytes(int64(p.Rate)))
+	switch {
+	case p.Failed:
+		s += " stopped"
+	case !p.Complete && p.ETA >= 0:
+		s = fmt.Sprintf("%s ETA %s", s, p.ETA)
+	}
+	return s
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,165 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/ecnahc515/core/coreos"
+	"github.com/spf13/cobra"
+)
+
+var ReleasesCmd = &cobra.Command{
+	Use:   "releases [channel] [version]",
+	Short: "List and describe remote CoreOS releases",
+	Long: `Lists the versions released in a channel, or every channel if none is given,
+marking the current release and those already downloaded. Given a version, or
+"current", describes that release instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		listReleases(cmd, args)
+	},
+}
+
+var releasesOutput string
+
+func init() {
+	ReleasesCmd.Flags().StringVarP(&releasesOutput, "output", "o", "table", "Output format, table or json")
+	ReleasesCmd.Flags().StringVar(&coreCfg.Board, "board", coreos.DefaultBoard, "CoreOS image board, amd64-usr or arm64-usr")
+}
+
+// releaseListing is one version in the output of core releases.
+type releaseListing struct {
+	Channel string `json:"channel"`
+	Version string `json:"version"`
+	Current bool   `json:"current"`
+	Cached  bool   `json:"cached"`
+}
+
+// releaseDescription is the output of core releases channel version.
+type releaseDescription struct {
+	coreos.Release
+	Cached bool `json:"cached"`
+}
+
+func listReleases(cmd *cobra.Command, args []string) {
+	InitializeConfig()
+	if len(args) > 2 || (releasesOutput != "table" && releasesOutput != "json") {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	client := newClient()
+	ctx, cancel := signalContext(os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if len(args) == 2 {
+		channel, version := args[0], args[1]
+		rel, err := client.GetRelease(ctx, channel, version)
+		if err != nil {
+			plog.Fatalf("Unable to describe CoreOS %s (%s). err: %s", channel, version, explainReleaseError(err))
+		}
+		desc := releaseDescription{Release: rel, Cached: cachedVersions(channel)[rel.Version]}
+		if releasesOutput == "json" {
+			printJSON(desc)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "Channel:\t%s\n", rel.Channel)
+		fmt.Fprintf(w, "Version:\t%s\n", rel.Version)
+		fmt.Fprintf(w, "Build:\t%s\n", rel.Build)
+		fmt.Fprintf(w, "Branch:\t%s\n", rel.Branch)
+		fmt.Fprintf(w, "Patch:\t%s\n", rel.Patch)
+		if rel.BuildID != "" {
+			fmt.Fprintf(w, "Build ID:\t%s\n", rel.BuildID)
+		}
+		fmt.Fprintf(w, "SDK version:\t%s\n", rel.SDKVersion)
+		fmt.Fprintf(w, "Cached:\t%t\n", desc.Cached)
+		w.Flush()
+		return
+	}
+
+	channels := coreos.Channels
+	if len(args) == 1 {
+		channels = args
+	}
+	var listings []releaseListing
+	for _, channel := range channels {
+		l, err := channelListing(ctx, client, channel)
+		if err != nil && len(channels) > 1 {
+			plog.Errorf("Unable to list releases for channel %s. err: %s", channel, explainReleaseError(err))
+			continue
+		}
+		if err != nil {
+			plog.Fatalf("Unable to list releases for channel %s. err: %s", channel, explainReleaseError(err))
+		}
+		listings = append(listings, l...)
+	}
+
+	if releasesOutput == "json" {
+		printJSON(listings)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANNEL\tVERSION\tCURRENT\tCACHED")
+	for _, l := range listings {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", l.Channel, l.Version, yesNo(l.Current), yesNo(l.Cached))
+	}
+	w.Flush()
+}
+
+// channelListing lists the versions released in channel, newest first. If
+// the release server doesn't list them, only the current version is listed.
+func channelListing(ctx context.Context, client *coreos.Client, channel string) ([]releaseListing, error) {
+	current, err := client.GetVersionID(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := client.ListVersions(ctx, channel)
+	if err != nil {
+		plog.Warningf("Unable to list every release in channel %s, showing only the current one. err: %v", channel, err)
+		versions = []string{current}
+	}
+	cached := cachedVersions(channel)
+	listings := make([]releaseListing, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		version := versions[i]
+		listings = append(listings, releaseListing{
+			Channel: channel,
+			Version: version,
+			Current: version == current,
+			Cached:  cached[version],
+		})
+	}
+	return listings, nil
+}
+
+// cachedVersions returns the set of versions of channel in the image
+// directory, for the board given by --board.
+func cachedVersions(channel string) map[string]bool {
+	cached := make(map[string]bool)
+	versions, err := coreos.LocalVersions(provider, channel, coreCfg.Board, coreCfg.ImageDirectory)
+	if err != nil {
+		plog.Warningf("Unable to list cached images. err: %v", err)
+	}
+	for _, v := range versions {
+		cached[v] = true
+	}
+	return cached
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		plog.Fatalf("Unable to write JSON. err: %v", err)
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
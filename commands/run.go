@@ -19,9 +19,13 @@ var RunCmd = &cobra.Command{
 }
 
 var (
-	coreCfg  coreos.Config
-	xhyveCfg xhyve.Config
-	dryRun   bool
+	coreCfg     coreos.Config
+	xhyveCfg    xhyve.Config
+	dryRun      bool
+	validate    bool
+	verifyImage bool
+	customImage string
+	hostAddress string
 )
 
 func init() {
@@ -34,23 +38,56 @@ func init() {
 
 	// CoreOS specific
 	RunCmd.PersistentFlags().StringVar(&coreCfg.Root, "root", "", "Path to disk image to be used as the root disk of the VM")
-	RunCmd.PersistentFlags().StringVar(&coreCfg.Version, "version", "", "CoreOS image version")
+	RunCmd.PersistentFlags().StringVar(&coreCfg.Version, "version", "", "CoreOS image version, or a version specifier such as latest, 1010.x, >=1000.0.0 or stable~1, resolved against local images")
 	RunCmd.PersistentFlags().StringVar(&coreCfg.Channel, "channel", "alpha", "CoreOS image channel")
-	RunCmd.PersistentFlags().StringVar(&coreCfg.CloudConfig, "cloud-config", "", "URL or Path to a cloud-config")
-	RunCmd.PersistentFlags().StringVar(&coreCfg.Cmdline, "cmdline", "", "Additional kernel cmdline parameters")
+	RunCmd.PersistentFlags().StringVar(&coreCfg.Board, "board", coreos.DefaultBoard, "CoreOS image board, amd64-usr or arm64-usr")
+	RunCmd.PersistentFlags().StringVar(&coreCfg.CloudConfig, "cloud-config", "", "URL of a cloud-config, or the path of one to serve to the VM")
+	RunCmd.PersistentFlags().StringVar(&coreCfg.Ignition, "ignition", "", "URL of an Ignition config, or the path of one to serve to the VM")
+	RunCmd.PersistentFlags().StringVar(&coreCfg.Cmdline, "cmdline", "", "Kernel cmdline parameters; key=value replaces a default parameter of the same name and -key removes it")
 	RunCmd.PersistentFlags().StringVar(&coreCfg.SSHKey, "sshkey", "", "Path to ssh public key")
+	RunCmd.PersistentFlags().StringVar(&coreCfg.Kernel, "kernel", "", "Path to a custom kernel to boot instead of a local image, with --initrd")
+	RunCmd.PersistentFlags().StringVar(&coreCfg.Initrd, "initrd", "", "Path to a custom initrd to boot instead of a local image, with --kernel")
+	RunCmd.PersistentFlags().StringVar(&customImage, "image", "", "Name of a custom image added with core images add to boot")
+	RunCmd.PersistentFlags().BoolVar(&verifyImage, "verify", false, "Check the image against its recorded digests and signatures before booting it")
+	RunCmd.PersistentFlags().StringVar(&hostAddress, "host-address", "", "Address of the host on the VM network to serve local configs on, read from vmnet's settings if empty")
+	RunCmd.PersistentFlags().BoolVar(&validate, "validate", true, "Check the cloud-config and Ignition config before booting, and refuse to boot if they have errors")
 	RunCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Do all of the setup, but do not start the VM")
 }
 
 func runXhyve() {
 	InitializeConfig()
-	if coreCfg.Version == "" {
-		var err error
-		coreCfg.Version, err = coreos.GetLatestImage(coreCfg.Channel, coreCfg.ImageDirectory)
+	if arch := coreos.BoardArch(coreCfg.Board); arch != xhyve.Arch {
+		plog.Fatalf("can't run %s images, xhyve can only boot %s kernels", coreCfg.Board, xhyve.Arch)
+	}
+	if coreCfg.Kernel != "" || coreCfg.Initrd != "" {
+		if coreCfg.Kernel == "" || coreCfg.Initrd == "" || customImage != "" {
+			plog.Fatalf("--kernel and --initrd have to be given together, and without --image")
+		}
+		plog.Infof("Using custom kernel %s and initrd %s", coreCfg.Kernel, coreCfg.Initrd)
+	} else {
+		store, image := findRunImage()
+		// keep the image from being pruned or replaced while the VM runs, and
+		// so between verifying it and booting it
+		lock, err := store.Use(image)
 		if err != nil {
-			plog.Fatalf("couldn't find anything to load locally (%s channel). please run `core fetch` first. err: %v", coreCfg.Channel, err)
+			plog.Fatalf("unable to lock %s. err: %v", image, err)
+		}
+		defer lock.Unlock()
+		if verifyImage {
+			verifyRunImage(store, image)
 		}
-		plog.Infof("No version specified, using latest local image: CoreOS %s (%s)", coreCfg.Channel, coreCfg.Version)
+		if !dryRun {
+			if err := store.Touch(image); err != nil {
+				plog.Warningf("Unable to record that %s was used. err: %v", image, err)
+			}
+		}
+	}
+	if validate {
+		validateRunConfigs()
+	}
+	server := serveLocalConfigs()
+	if server != nil {
+		defer server.Close()
 	}
 	kernelCfg, err := coreos.NewKernelConfig(coreCfg)
 	if err != nil {
@@ -63,7 +100,7 @@ func runXhyve() {
 	}
 	cmd, err := xhyve.Command(xhyveCfg)
 	if err != nil {
-		plog.Errorf("error creating command: %v", err)
+		plog.Fatalf("error creating command: %v", err)
 	}
 	if dryRun {
 		plog.Infof("%s", strings.Join(cmd.Args, " "))
@@ -72,9 +109,121 @@ func runXhyve() {
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
 	cmd.Stdin = os.Stdin
+	if server != nil {
+		server.Start()
+	}
 	plog.Debugf("executing '%s'", strings.Join(cmd.Args, " "))
 	err = cmd.Run()
 	if err != nil {
 		plog.Errorf("error running xhyve: %v", err)
 	}
 }
+
+// findRunImage returns the local image chosen by --image, or by --channel and
+// --version.
+func findRunImage() (*coreos.Store, coreos.Image) {
+	store, err := coreos.OpenStore(coreCfg.ImageDirectory)
+	if err != nil {
+		plog.Fatalf("unable to open image store. err: %v", err)
+	}
+	if customImage != "" {
+		image, ok := store.Custom(customImage)
+		if !ok {
+			plog.Fatalf("there is no custom image called %s. add it with `core images add` first", customImage)
+		}
+		useImage(store, image)
+		plog.Infof("Using custom image: %s", customImage)
+		return store, image
+	}
+
+	spec, err := coreos.ParseVersionSpec(coreCfg.Version)
+	if err != nil {
+		plog.Fatalf("%v", err)
+	}
+	if spec.Channel != "" {
+		coreCfg.Channel = spec.Channel
+	}
+	versions := store.Versions(provider, coreCfg.Channel, coreCfg.Board)
+	if len(versions) == 0 {
+		plog.Fatalf("couldn't find anything to load locally (%s channel). please run `core fetch %s` first. err: %v", coreCfg.Channel, coreCfg.Channel, coreos.ErrNoLocalImages)
+	}
+	image, err := store.Find(provider, coreCfg.Channel, coreCfg.Board, spec)
+	if err != nil {
+		plog.Fatalf("couldn't find a local image matching %s, the local images are %v. please run `core fetch '%s@%s'` first. err: %v", spec.Query(), versions, coreCfg.Channel, spec.Query(), err)
+	}
+	useImage(store, image)
+	plog.Infof("Using local image: %s %s (%s)", provider.Name(), coreCfg.Channel, coreCfg.Version)
+	return store, image
+}
+
+// serveLocalConfigs serves a local --cloud-config or --ignition to the VM for
+// as long as it runs, and points the kernel config at their URLs. It returns
+// nil if there is nothing to serve.
+func serveLocalConfigs() *coreos.ConfigServer {
+	configs := []struct {
+		name   string
+		config *string
+	}{
+		{"cloud-config", &coreCfg.CloudConfig},
+		{"ignition", &coreCfg.Ignition},
+	}
+	var server *coreos.ConfigServer
+	for _, c := range configs {
+		if *c.config == "" || coreos.IsURL(*c.config) {
+			continue
+		}
+		if server == nil {
+			host := hostAddress
+			if host == "" {
+				host = xhyve.HostAddress()
+			}
+			var err error
+			if server, err = coreos.NewConfigServer(host); err != nil {
+				plog.Fatalf("unable to serve local configs on %s. err: %v", host, err)
+			}
+		}
+		u, err := server.Add(c.name, *c.config)
+		if err != nil {
+			plog.Fatalf("unable to serve %s. err: %v", c.name, err)
+		}
+		plog.Infof("Serving %s %s to the VM at %s", c.name, *c.config, u)
+		*c.config = u
+	}
+	return server
+}
+
+// useImage configures the kernel config to boot the files of image recorded
+// in the index.
+func useImage(store *coreos.Store, image coreos.Image) {
+	p, err := coreos.LookupProvider(image.Distro)
+	if err != nil {
+		plog.Fatalf("%v", err)
+	}
+	kernel, _ := image.File(p.Kernel())
+	initrd, _ := image.File(p.Initrd())
+	coreCfg.Distro = image.Distro
+	coreCfg.Board = image.Board
+	if !image.IsCustom() {
+		coreCfg.Channel = image.Channel
+		coreCfg.Version = image.Version
+	}
+	coreCfg.Kernel = store.Path(kernel.Path)
+	coreCfg.Initrd = store.Path(initrd.Path)
+}
+
+// verifyRunImage refuses to boot image if its files fail verification.
+func verifyRunImage(store *coreos.Store, image coreos.Image) {
+	report, err := store.Verify(image, newKeyring())
+	if err != nil {
+		plog.Fatalf("unable to verify %s. err: %v", image, err)
+	}
+	for _, f := range report.Files {
+		if f.Failed() {
+			plog.Fatalf("refusing to boot %s, %s is %s: %s. run `core images verify` to quarantine it", image, f.Name, f.Status, f.Error)
+		}
+		if f.Status == coreos.FileUnchecked {
+			plog.Warningf("Signature of %s wasn't checked: %s", f.Name, f.Error)
+		}
+	}
+	plog.Infof("Verified %s", image)
+}
@@ -0,0 +1,139 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ecnahc515/core/coreos"
+	"github.com/spf13/cobra"
+)
+
+var ValidateCmd = &cobra.Command{
+	Use:   "validate <config>...",
+	Short: "Check cloud-configs and Ignition configs for mistakes",
+	Long: `Checks configs, given as local paths or URLs, as core run does before booting
+a VM. Cloud-configs are checked for the #cloud-config header, the YAML syntax
+and the keys coreos-cloudinit knows about. Ignition configs, which are JSON,
+for the JSON syntax, a stable spec version and the keys of that spec. Exits
+with an error if any config has errors.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		validateConfigs(cmd, args)
+	},
+}
+
+var validateOutput string
+
+func init() {
+	ValidateCmd.Flags().StringVarP(&validateOutput, "output", "o", "table", "Output format, table or json")
+}
+
+// configValidation is the output of core validate for one config.
+type configValidation struct {
+	Config   string                `json:"config"`
+	Problems coreos.ConfigProblems `json:"problems"`
+	Error    string                `json:"error,omitempty"`
+}
+
+func validateConfigs(cmd *cobra.Command, args []string) {
+	InitializeConfig()
+	if len(args) == 0 || (validateOutput != "table" && validateOutput != "json") {
+		cmd.Usage()
+		os.Exit(1)
+	}
+
+	validations := []configValidation{}
+	failed := 0
+	for _, config := range args {
+		v := configValidation{Config: config, Problems: coreos.ConfigProblems{}}
+		data, err := coreos.LoadConfig(config)
+		if err != nil {
+			v.Error = err.Error()
+		} else if problems := validateConfig(data); problems != nil {
+			v.Problems = problems
+		}
+		if v.Error != "" || v.Problems.Failed() {
+			failed++
+		}
+		validations = append(validations, v)
+	}
+
+	if validateOutput == "json" {
+		printJSON(validations)
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "CONFIG\tLINE\tSEVERITY\tMESSAGE")
+		for _, v := range validations {
+			switch {
+			case v.Error != "":
+				fmt.Fprintf(w, "%s\t-\terror\tunable to read it: %s\n", v.Config, v.Error)
+			case len(v.Problems) == 0:
+				fmt.Fprintf(w, "%s\t-\tok\tno problems found\n", v.Config)
+			}
+			for _, p := range v.Problems {
+				line := "-"
+				if p.Line > 0 {
+					line = fmt.Sprint(p.Line)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.Config, line, p.Severity, p.Message)
+			}
+		}
+		w.Flush()
+	}
+	if failed > 0 {
+		plog.Fatalf("%d of %d configs have errors", failed, len(validations))
+	}
+}
+
+// validateConfig checks data as an Ignition config if it's JSON, or as user
+// data for coreos-cloudinit otherwise.
+func validateConfig(data []byte) coreos.ConfigProblems {
+	if coreos.IsIgnition(data) {
+		return coreos.ValidateIgnition(data, nil)
+	}
+	return coreos.ValidateCloudConfig(data)
+}
+
+// validateRunConfigs checks the --cloud-config and --ignition configs before
+// the VM boots with them, and refuses to boot it if they have errors.
+func validateRunConfigs() {
+	if coreCfg.CloudConfig != "" {
+		validateRunConfig("cloud-config", coreCfg.CloudConfig, coreos.ValidateCloudConfig)
+	}
+	if coreCfg.Ignition != "" {
+		p, err := coreos.LookupProvider(coreCfg.Distro)
+		if err != nil {
+			plog.Fatalf("%v", err)
+		}
+		support, err := p.Ignition(coreCfg.Version)
+		if err != nil {
+			plog.Fatalf("unable to boot with --ignition. err: %v", err)
+		}
+		validateRunConfig("Ignition config", coreCfg.Ignition, func(data []byte) coreos.ConfigProblems {
+			return coreos.ValidateIgnition(data, &support)
+		})
+	}
+}
+
+func validateRunConfig(what, config string, validate func([]byte) coreos.ConfigProblems) {
+	data, err := coreos.LoadConfig(config)
+	if err != nil {
+		if coreos.IsURL(config) {
+			// the VM may be able to reach it when the host can't
+			plog.Warningf("Unable to fetch the %s to check it. err: %v", what, err)
+			return
+		}
+		plog.Fatalf("unable to read the %s. err: %v", what, err)
+	}
+	problems := validate(data)
+	for _, p := range problems {
+		if p.Severity == coreos.SeverityError {
+			plog.Errorf("%s: %s", config, p)
+		} else {
+			plog.Warningf("%s: %s", config, p)
+		}
+	}
+	if problems.Failed() {
+		plog.Fatalf("refusing to boot with an invalid %s. fix it, or run with --validate=false", what)
+	}
+}
@@ -0,0 +1,62 @@
+package coreos
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Boards CoreOS is built for. A board is the architecture of a release, and
+// the name of the directory its files are published under.
+const (
+	BoardAMD64 = "amd64-usr"
+	BoardARM64 = "arm64-usr"
+
+	DefaultBoard = BoardAMD64
+)
+
+// Boards are the supported boards.
+var Boards = []string{BoardAMD64, BoardARM64}
+
+// ValidateBoard checks that board is one of Boards.
+func ValidateBoard(board string) error {
+	for _, b := range Boards {
+		if b == board {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown board %q, must be one of %s", board, strings.Join(Boards, ", "))
+}
+
+// BoardArch returns the architecture of board in the form used by GOARCH,
+// such as amd64 or arm64.
+func BoardArch(board string) string {
+	return strings.TrimSuffix(board, "-usr")
+}
+
+func orDefaultBoard(board string) string {
+	if board == "" {
+		return DefaultBoard
+	}
+	return board
+}
+
+// imageName is the prefix of the names of a release's files in the image
+// directory. Files of DefaultBoard keep the names they had before other
+// boards were supported.
+func imageName(channel, board, version string) string {
+	if board = orDefaultBoard(board); board != DefaultBoard {
+		return fmt.Sprintf("%s.%s.%s", channel, board, version)
+	}
+	return fmt.Sprintf("%s.%s", channel, version)
+}
+
+// imageFileName is the prefix of the names of the files of a release of p in
+// the image directory. Distributions other than DefaultProvider prefix it
+// with their name, so ones whose files are named alike don't overwrite each
+// other's.
+func imageFileName(p ReleaseProvider, channel, board, version string) string {
+	if name := orDefaultProvider(p).Name(); name != DefaultProvider.Name() {
+		return name + "." + imageName(channel, board, version)
+	}
+	return imageName(channel, board, version)
+}
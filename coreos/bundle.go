@@ -0,0 +1,388 @@
+package coreos
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// SourceBundle images were imported from a bundle made by Export.
+const SourceBundle = "bundle"
+
+// bundleManifest is the name of the manifest in a bundle, its first entry.
+const bundleManifest = "manifest.json"
+
+// bundleVersion is bumped whenever the bundle format changes incompatibly.
+const bundleVersion = 1
+
+// maxManifestSize is the largest bundle manifest that is read.
+const maxManifestSize = 16 << 20
+
+// ErrInvalidBundle is returned when a bundle is malformed or its files don't
+// match its manifest.
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+// BundleManifest describes the images in a bundle. Their files, signatures
+// and recorded digests are at the paths recorded in the index, relative to
+// the root of the bundle.
+type BundleManifest struct {
+	Version int     `json:"version"`
+	Images  []Image `json:"images"`
+}
+
+// Export writes images to w as a tar archive, gzip compressed if compress is
+// set, holding a manifest of their index records followed by their files,
+// signatures and recorded digests. Each file is checked against its recorded
+// digests as it's written. Custom images can't be exported. Exporting the
+// same images again gives the same archive.
+func (s *Store) Export(w io.Writer, images []Image, compress bool) error {
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+
+	manifest := BundleManifest{Version: bundleVersion}
+	for _, img := range images {
+		if img.IsCustom() {
+			return fmt.Errorf("%s is a custom image, only releases can be exported", img)
+		}
+		// when it was last used is particular to this machine
+		img.LastUsed = nil
+		manifest.Images = append(manifest.Images, img)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if err := writeTarFile(tw, bundleManifest, int64(len(data)), bytes.NewReader(data)); err != nil {
+		return err
+	}
+	for _, img := range images {
+		for _, f := range img.Files {
+			if err := s.exportFile(tw, f); err != nil {
+				return fmt.Errorf("unable to export %s: %w", img, err)
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
+
+// exportFile writes f, its signature and its recorded digests to tw.
+func (s *Store) exportFile(tw *tar.Writer, f ImageFile) error {
+	in, err := os.Open(s.Path(f.Path))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	digest := newDigester()
+	if err := writeTarFile(tw, f.Path, f.Size, io.TeeReader(in, digest)); err != nil {
+		return err
+	}
+	if err := f.Digests.Check(f.Path, digest.Sum()); err != nil {
+		return err
+	}
+
+	for _, name := range []string{f.Signature, f.Path + digestsSuffix} {
+		if name == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(s.Path(name))
+		if os.IsNotExist(err) && name != f.Signature {
+			// the digests are in the manifest as well
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, name, int64(len(data)), bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTarFile writes a file of size bytes read from r to tw, with fixed
+// ownership and times so that archives are reproducible.
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Unix(0, 0),
+		Typeflag: tar.TypeReg,
+		Format:   tar.FormatUSTAR,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	n, err := io.Copy(tw, io.LimitReader(r, size))
+	if err == nil && n != size {
+		err = fmt.Errorf("%s changed size while it was exported", name)
+	}
+	return err
+}
+
+// ImportBundle adds the images in a bundle made by Export to the store. Every
+// file is checked against the digests in the manifest, and every signature
+// against keyring, before any of them is added, so a bundle that fails a
+// check adds nothing. Images are then added one at a time: if adding one
+// fails, those already added are kept, and returned along with the error.
+// Only the directory of keyring is used, each image is checked with the keys
+// built into its own distribution. Images that are already in the store are
+// only replaced if force is set.
+func (s *Store) ImportBundle(name string, keyring *Keyring, force bool) ([]Image, error) {
+	tr, closer, err := openTar(name)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	stagingDir := path.Join(s.Directory, stagingDirectory)
+	if err := os.MkdirAll(stagingDir, 0700); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(stagingDir, "bundle")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	manifest, err := readManifest(tr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := extractBundle(tr, manifest, dir); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	// check everything before touching the store
+	images := make([]Image, len(manifest.Images))
+	staged := make([][]DownloadResult, len(manifest.Images))
+	for i, img := range manifest.Images {
+		if images[i], staged[i], err = validateBundleImage(dir, img, keyring); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", name, img, err)
+		}
+		p, _ := LookupProvider(img.Distro)
+		for j := range manifest.Images[:i] {
+			if manifest.Images[j].is(img.Distro, img.Channel, img.Board, img.Version) {
+				return nil, fmt.Errorf("%s: %w: %s is listed twice", name, ErrInvalidBundle, img)
+			}
+		}
+		if _, ok := s.Get(p, img.Channel, img.Board, img.Version); ok && !force {
+			return nil, fmt.Errorf("%w: %s", ErrImageExists, img)
+		}
+	}
+
+	var imported []Image
+	for i, img := range images {
+		if err := s.merge(img, staged[i]); err != nil {
+			return imported, fmt.Errorf("unable to import %s: %w", img, err)
+		}
+		plog.Infof("Imported %s", img)
+		imported = append(imported, img)
+	}
+	return imported, nil
+}
+
+// readManifest reads the manifest, the first entry of a bundle.
+func readManifest(tr *tar.Reader) (BundleManifest, error) {
+	var manifest BundleManifest
+	hdr, err := tr.Next()
+	if err != nil {
+		return manifest, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	if hdr.Name != bundleManifest {
+		return manifest, fmt.Errorf("%w: it doesn't start with %s", ErrInvalidBundle, bundleManifest)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(tr, maxManifestSize))
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("%w: unable to parse %s: %v", ErrInvalidBundle, bundleManifest, err)
+	}
+	if manifest.Version != bundleVersion {
+		return manifest, fmt.Errorf("%w: unsupported version %d", ErrInvalidBundle, manifest.Version)
+	}
+	if len(manifest.Images) == 0 {
+		return manifest, fmt.Errorf("%w: it holds no images", ErrInvalidBundle)
+	}
+	return manifest, nil
+}
+
+// extractBundle writes the files of a bundle into dir. Only files listed in
+// the manifest are accepted.
+func extractBundle(tr *tar.Reader, manifest BundleManifest, dir string) error {
+	expected := make(map[string]bool)
+	for _, img := range manifest.Images {
+		for _, f := range img.Files {
+			for _, name := range []string{f.Path, f.Signature, f.Path + digestsSuffix} {
+				if name != "" {
+					expected[name] = true
+				}
+			}
+		}
+	}
+	seen := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+		if !expected[hdr.Name] || hdr.Typeflag != tar.TypeReg || path.Base(hdr.Name) != hdr.Name || strings.HasPrefix(hdr.Name, ".") {
+			return fmt.Errorf("%w: unexpected entry %s", ErrInvalidBundle, hdr.Name)
+		}
+		if seen[hdr.Name] {
+			return fmt.Errorf("%w: %s is in it twice", ErrInvalidBundle, hdr.Name)
+		}
+		seen[hdr.Name] = true
+		out, err := os.OpenFile(path.Join(dir, hdr.Name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// validateBundleImage checks the extracted files of img against its record
+// and their signatures against the keys in keyring's directory and those
+// built into img's distribution. It returns the record to add to the store,
+// and the files to install. Files without a signature are recorded as
+// Unverified, whatever the manifest says.
+func validateBundleImage(dir string, img Image, keyring *Keyring) (Image, []DownloadResult, error) {
+	p, err := LookupProvider(img.Distro)
+	if err != nil {
+		return img, nil, err
+	}
+	keyring = &Keyring{Directory: keyring.Directory, Provider: p}
+	if !channelName.MatchString(img.Channel) {
+		return img, nil, fmt.Errorf("%w: invalid channel %q", ErrInvalidBundle, img.Channel)
+	}
+	if _, err := ParseVersion(img.Version); err != nil {
+		return img, nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	if err := ValidateBoard(img.Board); err != nil {
+		return img, nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	if !img.bootable(p) {
+		return img, nil, fmt.Errorf("%w: it doesn't have both a kernel and an initrd", ErrInvalidBundle)
+	}
+
+	out := Image{
+		Distro:  img.Distro,
+		Channel: img.Channel,
+		Board:   img.Board,
+		Version: img.Version,
+		Source:  SourceBundle,
+		Fetched: time.Now().UTC(),
+	}
+	var staged []DownloadResult
+	for _, f := range img.Files {
+		// bundles exported before the files of other distributions were
+		// prefixed with their name have them unprefixed
+		rel := imageFileName(p, img.Channel, img.Board, img.Version) + "." + f.Name
+		legacy := imageName(img.Channel, img.Board, img.Version) + "." + f.Name
+		if (f.Path != rel && f.Path != legacy) || (f.Signature != "" && f.Signature != f.Path+".sig") {
+			return img, nil, fmt.Errorf("%w: %s isn't where it belongs", ErrInvalidBundle, f.Name)
+		}
+		loc := path.Join(dir, f.Path)
+		fi, err := os.Stat(loc)
+		if err != nil {
+			return img, nil, fmt.Errorf("%w: %s is missing", ErrInvalidBundle, f.Path)
+		}
+		if fi.Size() != f.Size {
+			return img, nil, fmt.Errorf("%w: %s is %d bytes, expected %d", ErrInvalidBundle, f.Path, fi.Size(), f.Size)
+		}
+		actual, err := DigestFile(loc)
+		if err != nil {
+			return img, nil, err
+		}
+		if err := f.Digests.Check(loc, actual); err != nil {
+			return img, nil, err
+		}
+
+		// the manifest can't be trusted to say how the file was checked,
+		// only a signature checked here counts
+		res := DownloadResult{FileLocation: loc, Digests: actual}
+		f.Verified = Unverified
+		if f.Signature != "" {
+			res.SignatureLocation = path.Join(dir, f.Signature)
+			if err := verify(keyring, loc, res.SignatureLocation); err != nil {
+				return img, nil, err
+			}
+			f.Verified = VerifiedSignature
+		}
+		f.Digests = actual
+		f.Path = rel
+		if f.Signature != "" {
+			f.Signature = rel + ".sig"
+		}
+		out.Files = append(out.Files, f)
+		staged = append(staged, res)
+	}
+	return out, staged, nil
+}
+
+// merge moves the staged files of img into the image directory, replacing
+// any it already has, and adds it to the index.
+func (s *Store) merge(img Image, staged []DownloadResult) error {
+	p, err := LookupProvider(img.Distro)
+	if err != nil {
+		return err
+	}
+	lock, err := lockFetch(s.Directory, p, img.Channel, img.Board, img.Version)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	locs := make([]string, len(img.Files))
+	for i, f := range img.Files {
+		locs[i] = s.Path(f.Path)
+	}
+	if old, ok := s.Get(p, img.Channel, img.Board, img.Version); ok {
+		inUse, err := s.lockExclusive(old)
+		if err != nil {
+			return err
+		}
+		defer inUse.Unlock()
+		for _, f := range old.Files {
+			for _, name := range []string{s.Path(f.Path), s.Path(f.Signature), s.Path(f.Path) + digestsSuffix} {
+				if name != "" {
+					os.Remove(name)
+				}
+			}
+		}
+	}
+	if err := install(staged, locs); err != nil {
+		return err
+	}
+	return s.Put(img)
+}
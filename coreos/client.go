@@ -0,0 +1,341 @@
+package coreos
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrChannelNotFound = errors.New("channel not found")
+	ErrVersionNotFound = errors.New("version not found")
+	ErrServer          = errors.New("release server error")
+	// ErrBoardNotServed is returned when none of the mirrors serve a board.
+	ErrBoardNotServed = errors.New("no release URL serves board")
+)
+
+// ReleaseError is returned when a release can't be fetched from the release
+// servers. Err is one of ErrChannelNotFound, ErrVersionNotFound or ErrServer,
+// and Cause the underlying error.
+type ReleaseError struct {
+	Channel string
+	Version string
+	Err     error
+	Cause   error
+}
+
+func (e *ReleaseError) Error() string {
+	var msg string
+	switch e.Err {
+	case ErrChannelNotFound:
+		msg = fmt.Sprintf("channel %q not found on the release server", e.Channel)
+	case ErrVersionNotFound:
+		msg = fmt.Sprintf("version %s not found in channel %s", e.Version, e.Channel)
+	default:
+		msg = e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", msg, e.Cause)
+}
+
+func (e *ReleaseError) Unwrap() error {
+	return e.Err
+}
+
+// releaseError wraps err in a ReleaseError if it came from the release
+// server. notFound is the error to use if the file doesn't exist.
+func releaseError(err error, notFound error, channel, version string) error {
+	if err == nil || isCanceled(err) {
+		return err
+	}
+	var kind error
+	var se *statusError
+	switch {
+	case errors.As(err, &se) && se.StatusCode == http.StatusNotFound:
+		kind = notFound
+	case isTransient(err):
+		kind = ErrServer
+	default:
+		return err
+	}
+	return &ReleaseError{Channel: channel, Version: version, Err: kind, Cause: err}
+}
+
+// statusError is returned when a release server responds with an unexpected
+// status code.
+type statusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected response from %s: %s", e.URL, e.Status)
+}
+
+// timeoutError is returned when a request makes no progress for longer than
+// the retry policy's timeout.
+type timeoutError struct {
+	URL     string
+	Timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("request to %s made no progress for %s", e.URL, e.Timeout)
+}
+
+// isTransient reports whether err might go away by trying again: the server
+// couldn't be reached, the connection broke or timed out, or the server had
+// an error.
+func isTransient(err error) bool {
+	var se *statusError
+	var pe *os.PathError
+	switch {
+	case isCanceled(err), errors.Is(err, ErrBoardNotServed):
+		return false
+	case errors.As(err, &se):
+		return se.StatusCode >= 500 || se.StatusCode == http.StatusTooManyRequests
+	case errors.As(err, &pe):
+		// a problem with the local file, not the server
+		return false
+	}
+	// connection errors, timeouts or errors reading the response body
+	return true
+}
+
+// RetryPolicy controls how often transient failures are retried, and how
+// long a request may make no progress before it is abandoned.
+type RetryPolicy struct {
+	// Attempts is how many times each mirror is tried.
+	Attempts int
+	// InitialBackoff is how long to wait before the first retry. The wait
+	// doubles for each retry after that, up to MaxBackoff, with up to half
+	// of it randomized.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout is how long to wait for a response, or for more of the
+	// response body to arrive. Zero means wait forever.
+	Timeout time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:       3,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+	Timeout:        30 * time.Second,
+}
+
+// backoff returns how long to wait before the given retry, counting from 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	// wait somewhere between half and all of it, so clients that failed
+	// together don't all retry together
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// do calls fn until it succeeds, fails with an error that isn't transient,
+// or has been tried p.Attempts times.
+func (p RetryPolicy) do(ctx context.Context, what string, fn func() error) error {
+	attempts := p.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !isTransient(err) || attempt >= attempts {
+			return err
+		}
+		wait := p.backoff(attempt)
+		plog.Infof("Retrying %s in %s (attempt %d of %d), err: %v", what, wait.Round(time.Millisecond), attempt+1, attempts, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Client fetches files from the release servers.
+type Client struct {
+	// Provider of the releases, DefaultProvider if nil.
+	Provider ReleaseProvider
+	// Mirrors to fetch from, the provider's if empty.
+	Mirrors Mirrors
+	Retry   RetryPolicy
+	// Board to look up releases of, DefaultBoard if empty.
+	Board string
+}
+
+// DefaultClient fetches from DefaultMirrors with DefaultRetryPolicy.
+var DefaultClient = &Client{Mirrors: DefaultMirrors, Retry: DefaultRetryPolicy}
+
+func (c *Client) provider() ReleaseProvider {
+	return orDefaultProvider(c.Provider)
+}
+
+func (c *Client) mirrors() Mirrors {
+	if len(c.Mirrors) == 0 {
+		return c.provider().Mirrors()
+	}
+	return c.Mirrors
+}
+
+// failover calls fn with the URL of name on each mirror in turn, retrying
+// each according to the retry policy, until one succeeds.
+func (c *Client) failover(ctx context.Context, channel, board, name string, fn func(url string) error) error {
+	urls := c.mirrors().urls(channel, board, name)
+	if len(urls) == 0 {
+		return fmt.Errorf("%w %s", ErrBoardNotServed, orDefaultBoard(board))
+	}
+	var errs Errors
+	for _, u := range urls {
+		file := path.Base(name)
+		if name == "" {
+			file = "release listing"
+		}
+		what := fmt.Sprintf("%s from %s", file, hostOf(u))
+		err := c.Retry.do(ctx, what, func() error {
+			return fn(u)
+		})
+		if err == nil {
+			plog.Infof("Fetched %s", what)
+			return nil
+		}
+		if ctx.Err() != nil || !isTransient(err) {
+			return err
+		}
+		plog.Warningf("Unable to fetch %s, err: %v", what, err)
+		errs = append(errs, err)
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errs
+}
+
+// readFile returns the contents of name, a path relative to the release root
+// of channel and board. It's meant for small files, the timeout covers the
+// whole request.
+func (c *Client) readFile(ctx context.Context, channel, board, name string) ([]byte, error) {
+	var data []byte
+	err := c.failover(ctx, channel, board, name, func(u string) error {
+		ctx := ctx
+		if c.Retry.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.Retry.Timeout)
+			defer cancel()
+		}
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return &statusError{URL: u, StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+		data, err = ioutil.ReadAll(resp.Body)
+		return err
+	})
+	return data, err
+}
+
+// download downloads name, a path relative to the release root of channel
+// and board, into outputFile, hashing it into digest if that isn't nil. A
+// partial download left by a failed attempt is resumed by the next.
+func (c *Client) download(ctx context.Context, channel, board, name, outputFile string, progress ProgressFunc, digest *digester) error {
+	return c.failover(ctx, channel, board, name, func(u string) error {
+		return get(ctx, u, outputFile, progress, c.Retry.Timeout, digest)
+	})
+}
+
+// getDigests returns the published digests of name, a path relative to the
+// release root of channel and board, or nil if there aren't any.
+func (c *Client) getDigests(ctx context.Context, channel, board, name string) (Digests, error) {
+	data, err := c.readFile(ctx, channel, board, name+digestsSuffix)
+	var se *statusError
+	if errors.As(err, &se) && se.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseDigests(bytes.NewReader(data), path.Base(name))
+}
+
+// idleTimer cancels a request once it has made no progress for too long.
+// A nil *idleTimer never fires.
+type idleTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+	fired   int32
+}
+
+func newIdleTimer(timeout time.Duration, cancel context.CancelFunc) *idleTimer {
+	if timeout <= 0 {
+		return nil
+	}
+	t := &idleTimer{timeout: timeout}
+	t.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&t.fired, 1)
+		cancel()
+	})
+	return t
+}
+
+func (t *idleTimer) stop() {
+	if t != nil {
+		t.timer.Stop()
+	}
+}
+
+// reader resets the timer every time something is read from r.
+func (t *idleTimer) reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return idleReader{r: r, t: t}
+}
+
+// err replaces err with a timeoutError if the timer fired.
+func (t *idleTimer) err(url string, err error) error {
+	if err != nil && t != nil && atomic.LoadInt32(&t.fired) == 1 {
+		return &timeoutError{URL: url, Timeout: t.timeout}
+	}
+	return err
+}
+
+type idleReader struct {
+	r io.Reader
+	t *idleTimer
+}
+
+func (r idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.t.timer.Reset(r.t.timeout)
+	}
+	return n, err
+}
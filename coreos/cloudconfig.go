@@ -0,0 +1,417 @@
+package coreos
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cloudConfigHeader is the first line of every cloud-config.
+const cloudConfigHeader = "#cloud-config"
+
+// Severity is how serious a problem with a config is.
+type Severity string
+
+const (
+	// SeverityError problems keep the config, or part of it, from working.
+	SeverityError Severity = "error"
+	// SeverityWarning problems are probably mistakes, such as keys that are
+	// silently ignored.
+	SeverityWarning Severity = "warning"
+)
+
+// ConfigProblem is a problem found in a config.
+type ConfigProblem struct {
+	// Line is where the problem is, counting from 1, or 0 if it isn't about
+	// one line.
+	Line     int      `json:"line,omitempty"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+func (p ConfigProblem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// ConfigProblems are the problems found in a config, in line order.
+type ConfigProblems []ConfigProblem
+
+// Failed reports whether any of the problems is an error.
+func (ps ConfigProblems) Failed() bool {
+	for _, p := range ps {
+		if p.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadConfig reads the config at a URL or local path.
+func LoadConfig(config string) ([]byte, error) {
+	if !IsURL(config) {
+		return ioutil.ReadFile(config)
+	}
+	client := &http.Client{Timeout: DefaultRetryPolicy.Timeout}
+	resp, err := client.Get(config)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{URL: config, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// yamlErrorLine finds the line in the errors of the yaml package.
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// ValidateCloudConfig checks user data the way coreos-cloudinit reads it: a
+// cloud-config needs the #cloud-config header and has to be valid YAML, and
+// keys cloudinit doesn't know are silently ignored by it, so they're warned
+// about. Like cloudinit, dashes and underscores in keys are interchangeable.
+// Scripts, starting with #!, aren't checked.
+func ValidateCloudConfig(data []byte) ConfigProblems {
+	header := strings.TrimSuffix(strings.SplitN(string(data), "\n", 2)[0], "\r")
+	switch {
+	case strings.HasPrefix(header, "#!"):
+		return nil
+	case strings.TrimSpace(header) == cloudConfigHeader && header != cloudConfigHeader:
+		return ConfigProblems{{Line: 1, Severity: SeverityError, Message: fmt.Sprintf("the header has to be exactly %s, without surrounding spaces", cloudConfigHeader)}}
+	case header != cloudConfigHeader:
+		return ConfigProblems{{Line: 1, Severity: SeverityError, Message: fmt.Sprintf("missing %s header, the config would be ignored", cloudConfigHeader)}}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		msg := strings.TrimPrefix(err.Error(), "yaml: ")
+		line := 0
+		if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ = strconv.Atoi(m[1])
+			msg = m[2]
+		}
+		return ConfigProblems{{Line: line, Severity: SeverityError, Message: "invalid YAML: " + msg}}
+	}
+	v := &validator{what: "a cloud-config", normalizeKeys: true}
+	if len(doc.Content) > 0 {
+		v.check(doc.Content[0], cloudConfigSchema, "")
+	}
+	return v.sorted()
+}
+
+// schema describes what a node of a config may be.
+type schema struct {
+	kind yaml.Kind
+	// fields are the keys of a mapping, which can have any keys if nil.
+	fields   map[string]*schema
+	required []string
+	// items describes the items of a sequence.
+	items *schema
+	// check returns a problem with a scalar's value, if it has one.
+	check func(value string) string
+}
+
+func mapping(fields map[string]*schema, required ...string) *schema {
+	return &schema{kind: yaml.MappingNode, fields: fields, required: required}
+}
+
+func sequence(items *schema) *schema {
+	return &schema{kind: yaml.SequenceNode, items: items}
+}
+
+func scalar() *schema {
+	return &schema{kind: yaml.ScalarNode}
+}
+
+func boolean() *schema {
+	return &schema{kind: yaml.ScalarNode, check: func(value string) string {
+		switch strings.ToLower(value) {
+		case "true", "false", "yes", "no", "on", "off":
+			return ""
+		}
+		return "should be true or false"
+	}}
+}
+
+func oneOf(values ...string) *schema {
+	return &schema{kind: yaml.ScalarNode, check: func(value string) string {
+		for _, v := range values {
+			if value == v {
+				return ""
+			}
+		}
+		return fmt.Sprintf("should be one of %s", strings.Join(values, ", "))
+	}}
+}
+
+func matching(re *regexp.Regexp, what string) *schema {
+	return &schema{kind: yaml.ScalarNode, check: func(value string) string {
+		if re.MatchString(value) {
+			return ""
+		}
+		return "should be " + what
+	}}
+}
+
+// options is a mapping of single values, as the options of CoreOS services.
+func options(keys ...string) *schema {
+	fields := make(map[string]*schema)
+	for _, k := range keys {
+		fields[k] = scalar()
+	}
+	return mapping(fields)
+}
+
+// unitSuffixes are the types of units systemd and networkd know about.
+var unitSuffixes = []string{
+	".service", ".socket", ".device", ".mount", ".automount", ".swap",
+	".target", ".path", ".timer", ".slice", ".scope",
+	".network", ".netdev", ".link",
+}
+
+// cloudConfigSchema is what coreos-cloudinit reads from a cloud-config.
+var cloudConfigSchema = mapping(map[string]*schema{
+	"hostname":            scalar(),
+	"manage_etc_hosts":    oneOf("localhost"),
+	"ssh_authorized_keys": sequence(scalar()),
+	"coreos": mapping(map[string]*schema{
+		"etcd": options("name", "addr", "bind_addr", "ca_file", "cert_file",
+			"cluster_active_size", "cluster_remove_delay", "cluster_sync_interval",
+			"cors", "cpu_profile_file", "data_dir", "discovery", "graphite_host",
+			"http_read_timeout", "http_write_timeout", "key_file", "peers",
+			"peers_file", "max_result_buffer", "max_retry_attempts",
+			"peer_addr", "peer_bind_addr", "peer_ca_file", "peer_cert_file",
+			"peer_key_file", "peer_election_timeout", "peer_heartbeat_interval",
+			"retry_interval", "snapshot", "snapshot_count", "strtrace",
+			"verbose", "very_verbose"),
+		"etcd2": options("name", "data_dir", "wal_dir", "snapshot_count",
+			"heartbeat_interval", "election_timeout", "listen_peer_urls",
+			"listen_client_urls", "max_snapshots", "max_wals", "cors",
+			"initial_advertise_peer_urls", "initial_cluster",
+			"initial_cluster_state", "initial_cluster_token",
+			"advertise_client_urls", "discovery", "discovery_srv",
+			"discovery_fallback", "discovery_proxy", "strict_reconfig_check",
+			"proxy", "proxy_failure_wait", "proxy_refresh_interval",
+			"proxy_dial_timeout", "proxy_write_timeout", "proxy_read_timeout",
+			"ca_file", "cert_file", "key_file", "client_cert_auth",
+			"trusted_ca_file", "auto_tls", "peer_ca_file", "peer_cert_file",
+			"peer_key_file", "peer_client_cert_auth", "peer_trusted_ca_file",
+			"peer_auto_tls", "debug", "log_package_levels", "force_new_cluster",
+			"enable_pprof"),
+		"fleet": options("agent_ttl", "authorized_keys_file",
+			"disable_engine", "disable_watches", "engine_reconcile_interval",
+			"etcd_cafile", "etcd_certfile", "etcd_keyfile", "etcd_key_prefix",
+			"etcd_request_timeout", "etcd_servers", "etcd_username",
+			"etcd_password", "metadata", "public_ip", "token_limit",
+			"verbosity", "verify_units"),
+		"flannel": options("etcd_endpoints", "etcd_cafile", "etcd_certfile",
+			"etcd_keyfile", "etcd_prefix", "etcd_username", "etcd_password",
+			"ip_masq", "subnet_file", "interface", "public_ip"),
+		"locksmith": options("endpoint", "etcd_cafile", "etcd_certfile",
+			"etcd_keyfile", "etcd_username", "etcd_password", "group",
+			"window_start", "window_length"),
+		"update": mapping(map[string]*schema{
+			"reboot_strategy": oneOf("reboot", "etcd-lock", "best-effort", "off"),
+			"server":          scalar(),
+			"group":           scalar(),
+		}),
+		"oem": options("id", "name", "version_id", "home_url", "bug_report_url"),
+		"units": sequence(mapping(map[string]*schema{
+			"name":    {kind: yaml.ScalarNode, check: checkUnitName},
+			"runtime": boolean(),
+			"enable":  boolean(),
+			"mask":    boolean(),
+			"content": scalar(),
+			"command": oneOf("start", "stop", "restart", "reload", "try-restart",
+				"reload-or-restart", "reload-or-try-restart"),
+			"drop_ins": sequence(mapping(map[string]*schema{
+				"name":    matching(regexp.MustCompile(`\.conf$`), "the name of a .conf file"),
+				"content": scalar(),
+			}, "name")),
+		}, "name")),
+	}),
+	"write_files": sequence(mapping(map[string]*schema{
+		"path":    matching(regexp.MustCompile(`^/`), "an absolute path"),
+		"content": scalar(),
+		"owner":   scalar(),
+		"permissions": matching(regexp.MustCompile(`^0?[0-7]{3,4}$`),
+			"an octal mode such as '0644'"),
+		"encoding": oneOf("b64", "base64", "gz", "gzip", "gz+b64",
+			"gz+base64", "gzip+b64", "gzip+base64"),
+	}, "path")),
+	"users": sequence(mapping(map[string]*schema{
+		"name":                           scalar(),
+		"passwd":                         scalar(),
+		"ssh_authorized_keys":            sequence(scalar()),
+		"coreos_ssh_import_github":       scalar(),
+		"coreos_ssh_import_github_users": sequence(scalar()),
+		"coreos_ssh_import_url":          scalar(),
+		"gecos":                          scalar(),
+		"homedir":                        scalar(),
+		"no_create_home":                 boolean(),
+		"primary_group":                  scalar(),
+		"groups":                         sequence(scalar()),
+		"no_user_group":                  boolean(),
+		"system":                         boolean(),
+		"no_log_init":                    boolean(),
+		"shell":                          scalar(),
+	}, "name")),
+})
+
+func checkUnitName(name string) string {
+	for _, suffix := range unitSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("%q isn't the name of a unit, such as etcd2.service", name)
+}
+
+// validator collects the problems found checking a config against a schema.
+type validator struct {
+	// what the config is, for messages.
+	what string
+	// normalizeKeys treats dashes in keys as underscores.
+	normalizeKeys bool
+	problems      ConfigProblems
+}
+
+// sorted returns the problems found in line order.
+func (v *validator) sorted() ConfigProblems {
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		return v.problems[i].Line < v.problems[j].Line
+	})
+	return v.problems
+}
+
+func (v *validator) add(line int, severity Severity, format string, args ...interface{}) {
+	v.problems = append(v.problems, ConfigProblem{Line: line, Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// check checks n, found at where in the config, against s.
+func (v *validator) check(n *yaml.Node, s *schema, where string) {
+	for n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	// empty values are left out
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return
+	}
+	if where == "" && n.Kind != s.kind {
+		v.add(n.Line, SeverityError, "%s has to be a mapping of keys to values", v.what)
+		return
+	}
+	switch s.kind {
+	case yaml.MappingNode:
+		if n.Kind != yaml.MappingNode {
+			v.add(n.Line, SeverityError, "%s should be a mapping of keys to values", where)
+			return
+		}
+		v.checkMapping(n, s, where)
+	case yaml.SequenceNode:
+		if n.Kind != yaml.SequenceNode {
+			v.add(n.Line, SeverityError, "%s should be a list", where)
+			return
+		}
+		for i, item := range n.Content {
+			v.check(item, s.items, fmt.Sprintf("%s[%d]", where, i))
+		}
+	case yaml.ScalarNode:
+		if n.Kind != yaml.ScalarNode {
+			v.add(n.Line, SeverityError, "%s should be a single value", where)
+			return
+		}
+		if s.check != nil {
+			if msg := s.check(n.Value); msg != "" {
+				v.add(n.Line, SeverityError, "%s %s", where, msg)
+			}
+		}
+	}
+}
+
+func (v *validator) checkMapping(n *yaml.Node, s *schema, where string) {
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		name := key.Value
+		if v.normalizeKeys {
+			name = strings.Replace(name, "-", "_", -1)
+		}
+		path := name
+		if where != "" {
+			path = where + "." + name
+		}
+		if seen[name] {
+			v.add(key.Line, SeverityError, "%s is given more than once", path)
+		}
+		seen[name] = true
+		if s.fields == nil {
+			continue
+		}
+		field, ok := s.fields[name]
+		if !ok {
+			msg := fmt.Sprintf("unknown key %q", key.Value)
+			if where != "" {
+				msg += " in " + where
+			}
+			if suggestion := closest(name, s.fields); suggestion != "" {
+				msg += fmt.Sprintf(", did you mean %q?", suggestion)
+			}
+			v.add(key.Line, SeverityWarning, "%s", msg)
+			continue
+		}
+		v.check(value, field, path)
+	}
+	for _, name := range s.required {
+		if !seen[name] {
+			v.add(n.Line, SeverityError, "%s is missing %s", where, name)
+		}
+	}
+}
+
+// closest returns the field name is probably a typo of, if there is one.
+func closest(name string, fields map[string]*schema) string {
+	best, bestDistance := "", 3
+	for field := range fields {
+		if d := editDistance(name, field); d < bestDistance || d == bestDistance && field < best {
+			best, bestDistance = field, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,158 @@
+package coreos
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// IsURL reports whether config is the URL of a config rather than a local
+// path.
+func IsURL(config string) bool {
+	u, err := url.Parse(config)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// ConfigServer serves local config files to a VM over HTTP, on the host's
+// address on the VM network. Files are served under a random token, so only
+// the VM they were given to can guess their URLs.
+type ConfigServer struct {
+	host  string
+	port  int
+	token string
+
+	mu       sync.Mutex
+	files    map[string]string
+	listener net.Listener
+	server   *http.Server
+	closed   bool
+}
+
+// NewConfigServer returns a server for configs on host, listening on a port
+// chosen for it. It isn't serving until Start is called.
+func NewConfigServer(host string) (*ConfigServer, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	s := &ConfigServer{
+		host:  host,
+		token: hex.EncodeToString(token),
+		files: make(map[string]string),
+	}
+	// vmnet only brings up the host's interface once a VM is started, so
+	// until it's up the port is held on every address, and requests that
+	// don't arrive on the host's are refused
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if isAddrNotAvailable(err) {
+		l, err = net.Listen("tcp", ":0")
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.listener = l
+	s.port = l.Addr().(*net.TCPAddr).Port
+	return s, nil
+}
+
+func isAddrNotAvailable(err error) bool {
+	return errors.Is(err, syscall.EADDRNOTAVAIL)
+}
+
+// Add serves the local file name as config and returns its URL.
+func (s *ConfigServer) Add(config, name string) (string, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	if fi.IsDir() {
+		return "", fmt.Errorf("%s is a directory", name)
+	}
+	s.mu.Lock()
+	s.files[config] = name
+	s.mu.Unlock()
+	u := url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort(s.host, strconv.Itoa(s.port)),
+		Path:   path.Join("/", s.token, config),
+	}
+	return u.String(), nil
+}
+
+// Start serves the configs in the background until Close is called.
+func (s *ConfigServer) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.server = &http.Server{Handler: s}
+	go s.serve(s.listener)
+}
+
+func (s *ConfigServer) serve(l net.Listener) {
+	plog.Infof("Serving configs to the VM on %s", l.Addr())
+	if err := s.server.Serve(l); err != nil && err != http.ErrServerClosed {
+		plog.Errorf("Error serving configs. err: %v", err)
+	}
+}
+
+// ServeHTTP serves the config a request is for, if it has the right token.
+func (s *ConfigServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	dir, config := path.Split(r.URL.Path)
+	s.mu.Lock()
+	name, ok := s.files[config]
+	s.mu.Unlock()
+	// compared in constant time, so the token can't be guessed byte by byte
+	validToken := subtle.ConstantTimeCompare([]byte(dir), []byte("/"+s.token+"/")) == 1
+	local, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !validToken || !ok || !s.onHost(local) || r.Method != http.MethodGet && r.Method != http.MethodHead {
+		plog.Warningf("Refused request for %s from %s", r.URL.Path, r.RemoteAddr)
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		plog.Errorf("Unable to serve %s to %s. err: %v", name, r.RemoteAddr, err)
+		http.Error(w, "config unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, "config unavailable", http.StatusInternalServerError)
+		return
+	}
+	plog.Infof("VM at %s fetched its %s, %s", r.RemoteAddr, config, name)
+	http.ServeContent(w, r, config, fi.ModTime(), f)
+}
+
+// onHost reports whether a request that arrived on the local address addr
+// came in on the host's address, rather than on another interface the
+// server listens on while the host's isn't up.
+func (s *ConfigServer) onHost(addr net.Addr) bool {
+	ip := net.ParseIP(s.host)
+	tcp, ok := addr.(*net.TCPAddr)
+	return ip == nil || ok && tcp.IP.Equal(ip)
+}
+
+// Close stops serving configs.
+func (s *ConfigServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.server != nil {
+		return s.server.Close()
+	}
+	return s.listener.Close()
+}
@@ -0,0 +1,99 @@
+package coreos
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ecnahc515/core/xhyve"
+)
+
+// CustomChannel prefixes the names of the files of custom images in the
+// image directory.
+const CustomChannel = "custom"
+
+// Custom returns the custom image called name.
+func (s *Store) Custom(name string) (Image, bool) {
+	for _, img := range s.images {
+		if img.Name == name {
+			return img, true
+		}
+	}
+	return Image{}, false
+}
+
+// AddCustom copies a locally built kernel and initrd into the image directory
+// as the custom image called name, which boots like an image of p. The
+// kernel has to be a bzImage for board and the initrd a gzip compressed cpio
+// archive. An existing custom image of the same name is only replaced if
+// force is set.
+func (s *Store) AddCustom(p ReleaseProvider, name, board, kernel, initrd string, force bool) (Image, error) {
+	p = orDefaultProvider(p)
+	board = orDefaultBoard(board)
+	if !channelName.MatchString(name) {
+		return Image{}, fmt.Errorf("invalid image name %q", name)
+	}
+	if err := ValidateBoard(board); err != nil {
+		return Image{}, err
+	}
+	if arch, err := xhyve.KernelArch(kernel); err != nil {
+		return Image{}, err
+	} else if arch != BoardArch(board) {
+		return Image{}, fmt.Errorf("%s isn't an %s kernel", kernel, BoardArch(board))
+	}
+	if BoardArch(board) == xhyve.Arch {
+		if _, err := xhyve.ReadKernelHeader(kernel); err != nil {
+			return Image{}, err
+		}
+	}
+	if err := xhyve.CheckInitrd(initrd); err != nil {
+		return Image{}, err
+	}
+
+	lock, err := lockFetch(s.Directory, p, CustomChannel, board, name)
+	if err != nil {
+		return Image{}, err
+	}
+	defer lock.Unlock()
+	if old, ok := s.Custom(name); ok {
+		if !force {
+			return Image{}, fmt.Errorf("%w: %s", ErrImageExists, old)
+		}
+		inUse, err := s.lockExclusive(old)
+		if err != nil {
+			return Image{}, err
+		}
+		defer inUse.Unlock()
+		if err := s.Delete(old); err != nil {
+			return Image{}, err
+		}
+		for _, f := range old.Files {
+			os.Remove(s.Path(f.Path))
+			os.Remove(s.Path(f.Path) + digestsSuffix)
+		}
+	}
+
+	img := Image{
+		Distro:  p.Name(),
+		Board:   board,
+		Name:    name,
+		Source:  SourceCustom,
+		Fetched: time.Now().UTC(),
+	}
+	for _, file := range []struct{ name, src string }{{p.Kernel(), kernel}, {p.Initrd(), initrd}} {
+		rel := imageName(CustomChannel, board, name) + "." + file.name
+		digest := newDigester()
+		if err := copyFile(file.src, s.Path(rel), digest); err != nil {
+			return Image{}, err
+		}
+		if err := RecordDigests(s.Path(rel), digest.Sum()); err != nil {
+			return Image{}, err
+		}
+		f, err := s.imageFile(rel, file.name, Unverified)
+		if err != nil {
+			return Image{}, err
+		}
+		img.Files = append(img.Files, f)
+	}
+	return img, s.Put(img)
+}
@@ -0,0 +1,217 @@
+package coreos
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// digestsSuffix is appended to a release file's name to get the file listing
+// its digests, both on the release server and next to cached images.
+const digestsSuffix = ".DIGESTS"
+
+// digestHashes are the algorithms from DIGESTS files that are checked, by the
+// name used in the file.
+var digestHashes = map[string]func() hash.Hash{
+	"SHA1":   sha1.New,
+	"SHA512": sha512.New,
+}
+
+// Digests maps the name of a hash algorithm, as used in DIGESTS files, to the
+// hex encoded digest of a file.
+type Digests map[string]string
+
+// DigestError is returned when a file doesn't match its published digest.
+type DigestError struct {
+	File      string
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *DigestError) Error() string {
+	return fmt.Sprintf("%s digest of %s is %s, expected %s", e.Algorithm, path.Base(e.File), e.Actual, e.Expected)
+}
+
+// ParseDigests reads the digests of file out of a DIGESTS file. These list
+// each digest under a "# <ALGORITHM> HASH" header, as "<hex digest>  <file>".
+func ParseDigests(r io.Reader, file string) (Digests, error) {
+	digests := make(Digests)
+	var algorithm string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(strings.TrimPrefix(line, "#"))
+			algorithm = ""
+			if len(fields) == 2 && fields[1] == "HASH" {
+				algorithm = strings.ToUpper(fields[0])
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if algorithm == "" || len(fields) != 2 || fields[1] != file {
+			continue
+		}
+		sum, err := hex.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s digest for %s: %v", algorithm, file, err)
+		}
+		if h, ok := digestHashes[algorithm]; ok && len(sum) != h().Size() {
+			return nil, fmt.Errorf("invalid %s digest for %s: %d bytes long, expected %d", algorithm, file, len(sum), h().Size())
+		}
+		digests[algorithm] = strings.ToLower(fields[0])
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(digests) == 0 {
+		return nil, fmt.Errorf("no digests found for %s", file)
+	}
+	return digests, nil
+}
+
+// Check compares actual against d, checking every algorithm both know. It's
+// an error if they have no algorithms in common.
+func (d Digests) Check(file string, actual Digests) error {
+	checked := 0
+	for _, algorithm := range d.algorithms() {
+		got, ok := actual[algorithm]
+		if !ok {
+			continue
+		}
+		if got != d[algorithm] {
+			return &DigestError{File: file, Algorithm: algorithm, Expected: d[algorithm], Actual: got}
+		}
+		checked++
+	}
+	if checked == 0 {
+		return fmt.Errorf("no supported digests for %s", path.Base(file))
+	}
+	return nil
+}
+
+func (d Digests) algorithms() []string {
+	var algorithms []string
+	for algorithm := range d {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+	return algorithms
+}
+
+// Write writes d in the DIGESTS file format, listing them for file.
+func (d Digests) Write(w io.Writer, file string) error {
+	for _, algorithm := range d.algorithms() {
+		_, err := fmt.Fprintf(w, "# %s HASH\n%s  %s\n", algorithm, d[algorithm], file)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// digester computes the digests of everything written to it.
+type digester struct {
+	hashes map[string]hash.Hash
+}
+
+func newDigester() *digester {
+	d := &digester{hashes: make(map[string]hash.Hash)}
+	for algorithm, h := range digestHashes {
+		d.hashes[algorithm] = h()
+	}
+	return d
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	for _, h := range d.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+func (d *digester) Reset() {
+	for _, h := range d.hashes {
+		h.Reset()
+	}
+}
+
+func (d *digester) Sum() Digests {
+	digests := make(Digests)
+	for algorithm, h := range d.hashes {
+		digests[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	return digests
+}
+
+// readFrom feeds the first n bytes of the named file, or all of it if n is
+// negative, into the digester.
+func (d *digester) readFrom(name string, n int64) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if n < 0 {
+		_, err = io.Copy(d, f)
+	} else {
+		_, err = io.CopyN(d, f, n)
+	}
+	return err
+}
+
+// DigestFile computes the digests of the named file.
+func DigestFile(name string) (Digests, error) {
+	d := newDigester()
+	if err := d.readFrom(name, -1); err != nil {
+		return nil, err
+	}
+	return d.Sum(), nil
+}
+
+// RecordDigests saves digests for the named file next to it, so the file can
+// be checked later with VerifyDigests.
+func RecordDigests(name string, digests Digests) error {
+	f, err := os.OpenFile(name+digestsSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	err = digests.Write(f, path.Base(name))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ReadDigests returns the digests recorded for the named file.
+func ReadDigests(name string) (Digests, error) {
+	f, err := os.Open(name + digestsSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseDigests(f, path.Base(name))
+}
+
+// VerifyDigests checks the named file against the digests recorded next to
+// it, without using the network.
+func VerifyDigests(name string) error {
+	expected, err := ReadDigests(name)
+	if err != nil {
+		return err
+	}
+	actual, err := DigestFile(name)
+	if err != nil {
+		return err
+	}
+	return expected.Check(name, actual)
+}
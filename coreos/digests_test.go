@@ -0,0 +1,150 @@
+package coreos
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testFile   = "coreos_production_pxe.vmlinuz"
+	testMD5    = "2f3cacd1d5c9c33b8de3fc08f8ff3883"
+	testSHA1   = "081b6754532b4022db4abece05140725e113c577"
+	testSHA512 = "e1ac6deb41bccb1536b2cb075c95f2dd2e3cd68244c55b110a712bdc5934f1d2ccab1a9f25b4918ae7ddcb65fb5fd7df69ebeb65eea0661d1ae68896b5b8661b"
+)
+
+func TestParseDigests(t *testing.T) {
+	tests := []struct {
+		name    string
+		digests string
+		want    Digests
+		err     bool
+	}{
+		{
+			name: "release",
+			digests: "# MD5 HASH\n" + testMD5 + "  " + testFile + "\n" +
+				"# SHA1 HASH\n" + testSHA1 + "  " + testFile + "\n" +
+				"# SHA512 HASH\n" + testSHA512 + "  " + testFile + "\n",
+			want: Digests{"MD5": testMD5, "SHA1": testSHA1, "SHA512": testSHA512},
+		},
+		{
+			name: "other files",
+			digests: "# SHA1 HASH\n" + testSHA1 + "  coreos_production_pxe_image.cpio.gz\n" +
+				strings.ToUpper(testSHA1) + "  " + testFile + "\n",
+			want: Digests{"SHA1": testSHA1},
+		},
+		{
+			name:    "lower case header",
+			digests: "# sha512 HASH\n" + testSHA512 + "  " + testFile + "\n",
+			want:    Digests{"SHA512": testSHA512},
+		},
+		{
+			name: "malformed lines",
+			digests: "\n" + testSHA1 + "  " + testFile + "\n" +
+				"# SHA1\n" + testSHA1 + "  " + testFile + "\n" +
+				"# SHA1 HASH\n" + testSHA1 + "\n" + testSHA1 + "  " + testFile + "  extra\n" +
+				"# SHA512 HASH\n  " + testSHA512 + "  " + testFile + "  \n",
+			want: Digests{"SHA512": testSHA512},
+		},
+		{
+			name:    "no header",
+			digests: testSHA1 + "  " + testFile + "\n",
+			err:     true,
+		},
+		{
+			name:    "not listed",
+			digests: "# SHA1 HASH\n" + testSHA1 + "  " + testFile + ".sig\n",
+			err:     true,
+		},
+		{
+			name:    "empty",
+			digests: "",
+			err:     true,
+		},
+		{
+			name:    "not hex",
+			digests: "# SHA1 HASH\n" + strings.Replace(testSHA1, "0", "g", 1) + "  " + testFile + "\n",
+			err:     true,
+		},
+		{
+			name:    "odd length",
+			digests: "# SHA1 HASH\n" + testSHA1[1:] + "  " + testFile + "\n",
+			err:     true,
+		},
+		{
+			name:    "wrong length",
+			digests: "# SHA512 HASH\n" + testSHA1 + "  " + testFile + "\n",
+			err:     true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseDigests(strings.NewReader(tt.digests), testFile)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: got %v, expected an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, expected %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDigestsCheck(t *testing.T) {
+	d := newDigester()
+	d.Write([]byte("vmlinuz"))
+	actual := d.Sum()
+
+	tests := []struct {
+		name      string
+		published Digests
+		algorithm string
+		err       bool
+	}{
+		{name: "all", published: Digests{"MD5": testMD5, "SHA1": testSHA1, "SHA512": testSHA512}},
+		{name: "sha1 only", published: Digests{"SHA1": testSHA1}},
+		{name: "sha1 mismatch", published: Digests{"SHA1": testSHA1[:39] + "0", "SHA512": testSHA512}, algorithm: "SHA1", err: true},
+		{name: "sha512 mismatch", published: Digests{"SHA1": testSHA1, "SHA512": testSHA1 + testSHA1}, algorithm: "SHA512", err: true},
+		{name: "none supported", published: Digests{"MD5": testMD5}, err: true},
+	}
+	for _, tt := range tests {
+		err := tt.published.Check(testFile, actual)
+		if !tt.err {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		var digestErr *DigestError
+		switch {
+		case err == nil:
+			t.Errorf("%s: expected an error", tt.name)
+		case tt.algorithm == "" && errors.As(err, &digestErr):
+			t.Errorf("%s: got %v, expected no digests to be compared", tt.name, err)
+		case tt.algorithm != "" && (!errors.As(err, &digestErr) || digestErr.Algorithm != tt.algorithm):
+			t.Errorf("%s: got %v, expected a %s DigestError", tt.name, err, tt.algorithm)
+		}
+	}
+}
+
+func TestDigestsWrite(t *testing.T) {
+	want := Digests{"MD5": testMD5, "SHA1": testSHA1, "SHA512": testSHA512}
+	var buf bytes.Buffer
+	if err := want.Write(&buf, testFile); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseDigests(&buf, testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read back %v, expected %v", got, want)
+	}
+}
@@ -1,7 +1,7 @@
 package coreos
 
 import (
-	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -9,7 +9,10 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/coreos/pkg/capnslog"
 )
@@ -17,184 +20,684 @@ import (
 var plog = capnslog.NewPackageLogger("github.com/ecnahc515/core", "coreos")
 
 const (
-	rootUrl = "http://%s.release.core-os.net/amd64-usr/%s"
+	// stagingDirectory holds partial downloads, inside the image directory,
+	// until they are complete and verified.
+	stagingDirectory = ".staging"
 )
 
-func getVersionURL(channel string) string {
-	return fmt.Sprintf(rootUrl, channel, "current/version.txt")
-}
+// DefaultParallelism is how many files a Downloader fetches at once unless
+// told otherwise.
+const DefaultParallelism = 4
 
-func getDownloadURL(channel, version string) string {
-	return fmt.Sprintf(rootUrl, channel, version)
-}
+// ErrStopped is returned by Downloader.Download once Stop has been called.
+var ErrStopped = errors.New("download stopped")
 
-func getFileURL(channel, version, file string) string {
-	root := getDownloadURL(channel, version)
-	return fmt.Sprintf("%s/%s", root, file)
-}
+// Errors is returned when more than one download fails.
+type Errors []error
 
-func GetVersionID(channel string) (string, error) {
-	const wantKey = "COREOS_VERSION_ID"
-	url := getVersionURL(channel)
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
 	}
-	defer resp.Body.Close()
-	r := bufio.NewReader(resp.Body)
-	line, err := r.ReadString('\n')
-	for err == nil {
-		items := strings.SplitN(strings.TrimSpace(line), "=", 2)
-		key, value := items[0], items[1]
-		if key == wantKey {
-			return value, nil
-		}
-		line, err = r.ReadString('\n')
-	}
-	return "", fmt.Errorf("Unable to find %s in response", wantKey)
+	return strings.Join(msgs, "; ")
+}
+
+func (e Errors) Unwrap() []error {
+	return e
 }
 
+// Downloader fetches and verifies the files of a release into the image
+// directory. It is safe to call Stop from another goroutine at any time.
 type Downloader struct {
-	stop           chan struct{}
-	stopDone       chan struct{}
-	Channel        string
-	Version        string
+	Channel string
+	Version string
+	// Board to download, DefaultBoard if empty.
+	Board          string
 	ImageDirectory string
-	tmpDir         string
+	// Provider of the release, DefaultProvider if nil. Used to record the
+	// release in the image store.
+	Provider ReleaseProvider
+	Keyring  *Keyring
+	// Client to download with, DefaultClient if nil.
+	Client *Client
+	// Parallelism limits how many files, counting signatures, are fetched
+	// at once. DefaultParallelism is used if it is not positive.
+	Parallelism int
+	// Progress, if set, is called as each file downloads. Calls are never
+	// made concurrently.
+	Progress ProgressFunc
+
+	mu         sync.Mutex
+	progressMu sync.Mutex
+	stopped    bool
+	nextID     int
+	cancels    map[int]context.CancelFunc
 }
 
 func NewDownloader(channel, version, imageDirectory string) *Downloader {
 	return &Downloader{
-		stop:           make(chan struct{}),
-		stopDone:       make(chan struct{}),
 		Channel:        channel,
 		Version:        version,
 		ImageDirectory: imageDirectory,
+		Keyring:        NewKeyring(imageDirectory),
+		cancels:        make(map[int]context.CancelFunc),
 	}
 }
 
-func (d *Downloader) Download(file string) (err error) {
-	endFile := fmt.Sprintf("%s.%s.%s", d.Channel, d.Version, file)
-	loc := path.Join(d.ImageDirectory, endFile)
-	// check if we've already downloaded this
-	if _, err := os.Stat(loc); err == nil {
-		plog.Infof("Found cached %s (%s/%s)", file, d.Channel, d.Version)
-		return nil
+// Download concurrently fetches files and their signatures, verifies them and
+// moves them into the image directory. Either all of the files end up in the
+// image directory or none do: if any of them fails, the rest are canceled and
+// every failure is returned. Interrupted downloads are kept, closed, so the
+// next attempt can resume them, and a file that fails verification is
+// removed.
+func (d *Downloader) Download(ctx context.Context, files ...string) error {
+	// other processes downloading this version wait for this one, and then
+	// find its files cached
+	lock, err := lockFetch(d.ImageDirectory, d.Provider, d.Channel, d.Board, d.Version)
+	if err != nil {
+		return err
 	}
+	defer lock.Unlock()
 
-	errChan := make(chan error)
+	var missing []string
+	for _, file := range files {
+		// check if we've already downloaded this
+		loc := d.imagePath(file)
+		if _, err := os.Stat(loc); err == nil {
+			err = d.verifyCached(loc)
+			if err == nil {
+				plog.Infof("Found cached %s (%s/%s)", file, d.Channel, d.Version)
+				continue
+			}
+			plog.Warningf("Cached %s (%s/%s) failed verification, downloading it again. err: %v", file, d.Channel, d.Version, err)
+			inUse, err := lockImage(d.ImageDirectory, d.Provider, d.Channel, d.Board, d.Version, true)
+			if err != nil {
+				return fmt.Errorf("unable to replace %s: %w", path.Base(loc), err)
+			}
+			for _, name := range []string{loc, loc + ".sig", loc + digestsSuffix} {
+				os.Remove(name)
+			}
+			inUse.Unlock()
+		}
+		missing = append(missing, file)
+	}
+	if len(missing) == 0 {
+		return d.record(files, false)
+	}
 
-	if d.tmpDir == "" {
-		// create a directory to temporarily hold our downloads
-		d.tmpDir, err = ioutil.TempDir("", "coreos-install")
-		if err != nil {
-			return
+	ctx, done, err := d.start(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	// partial downloads are kept here so an interrupted download can be
+	// resumed the next time
+	err = os.MkdirAll(d.stagingDir(), 0700)
+	if err != nil {
+		return err
+	}
+
+	// the first failure cancels everything else
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sem := d.semaphore()
+	results := make([]DownloadResult, len(missing))
+	errs := make([]error, len(missing))
+	var wg sync.WaitGroup
+	for i, file := range missing {
+		wg.Add(1)
+		go func(i int, file string) {
+			defer wg.Done()
+			results[i], errs[i] = d.fetch(fetchCtx, sem, file, d.stagingDir())
+			if errs[i] != nil {
+				cancel()
+			}
+		}(i, file)
+	}
+	wg.Wait()
+
+	var failed Errors
+	for i, err := range errs {
+		if isVerificationError(err) {
+			// never leave an unverified file behind
+			results[i].remove()
+		}
+		if err != nil && !isCanceled(err) {
+			failed = append(failed, fmt.Errorf("%s: %w", missing[i], err))
 		}
 	}
+	var failure error
+	switch {
+	case len(failed) == 1:
+		failure = errs[indexOfFailure(errs)]
+	case len(failed) > 1:
+		failure = failed
+	}
+	if ctx.Err() != nil {
+		// a download that failed on its own while being stopped still
+		// reports why it failed
+		stop := d.stopErr(ctx)
+		if failure == nil || errors.Is(failure, stop) {
+			return stop
+		}
+		return Errors{failure, stop}
+	}
+	if failure != nil {
+		return failure
+	}
 
-	var res DownloadResult
-	go func() {
-		res, err = Download(d.Channel, d.Version, file, d.tmpDir)
-		errChan <- err
-	}()
+	locs := make([]string, len(missing))
+	for i, file := range missing {
+		locs[i] = d.imagePath(file)
+	}
+	if err := install(results, locs); err != nil {
+		return err
+	}
+	// only succeeds once every file for this version has been moved out
+	os.Remove(d.stagingDir())
 
-	// Wait for the download to finish or for it to be canceled
-	select {
-	case err := <-errChan:
+	return d.record(files, true)
+}
+
+// record adds the downloaded files to the image store. Cached files that are
+// already in it are left alone unless fresh is set.
+func (d *Downloader) record(files []string, fresh bool) error {
+	store, err := OpenStore(d.ImageDirectory)
+	if err != nil {
+		return err
+	}
+	if img, ok := store.Get(d.Provider, d.Channel, d.Board, d.Version); ok && !fresh {
+		complete := true
+		for _, file := range files {
+			if _, ok := img.File(file); !ok {
+				complete = false
+			}
+		}
+		if complete {
+			return nil
+		}
+	}
+	verified := make(map[string]Verification)
+	for _, file := range files {
+		verified[file] = VerifiedSignature
+	}
+	_, err = store.record(d.Provider, d.Channel, d.Board, d.Version, SourceDownload, verified)
+	if err != nil {
+		return fmt.Errorf("Unable to add %s %s (%s) to the image index: %v", orDefaultProvider(d.Provider).Name(), d.Channel, d.Version, err)
+	}
+	return nil
+}
+
+// install moves each staged file, its signature if it has one and its digests
+// into place at the matching location in locs, all or nothing.
+func install(staged []DownloadResult, locs []string) error {
+	var moved []string
+	undo := func() {
+		for _, name := range moved {
+			os.Remove(name)
+		}
+	}
+	for i, res := range staged {
+		loc := locs[i]
+		names := [][2]string{{res.FileLocation, loc}}
+		if res.SignatureLocation != "" {
+			names = append(names, [2]string{res.SignatureLocation, loc + ".sig"})
+		}
+		for _, names := range names {
+			src, dst := names[0], names[1]
+			if err := os.Rename(src, dst); err != nil {
+				undo()
+				return fmt.Errorf("Unable to move %s into %s: %v", path.Base(src), path.Dir(dst), err)
+			}
+			moved = append(moved, dst)
+		}
+		err := RecordDigests(loc, res.Digests)
+		moved = append(moved, loc+digestsSuffix)
 		if err != nil {
-			return err
+			undo()
+			return fmt.Errorf("Unable to record digests of %s: %v", path.Base(loc), err)
 		}
-	case <-d.stop:
-		d.Cleanup()
-		d.stopDone <- struct{}{}
-		return errors.New("Recieved cancel signal")
+		res.remove()
 	}
+	return nil
+}
 
-	// move the files into the final location
-	var errs []error
-	err = os.Rename(res.FileLocation, path.Join(d.ImageDirectory, endFile))
-	if err != nil {
-		errs = append(errs, err)
+// verifyCached checks a file in the image directory without using the
+// network: against its recorded digests or, if it was cached before digests
+// were recorded, its signature.
+func (d *Downloader) verifyCached(loc string) error {
+	err := VerifyDigests(loc)
+	if !os.IsNotExist(err) {
+		return err
 	}
-	err = os.Rename(res.SignatureLocation, path.Join(d.ImageDirectory, endFile+".sig"))
+	if err := verify(d.Keyring, loc, loc+".sig"); err != nil {
+		return err
+	}
+	digests, err := DigestFile(loc)
 	if err != nil {
-		errs = append(errs, err)
+		return err
 	}
+	return RecordDigests(loc, digests)
+}
 
-	if len(errs) != 0 {
-		err = fmt.Errorf("Encountered the following errors when moving files: %v", errs)
+// isVerificationError reports whether err means a downloaded file can't be
+// trusted.
+func isVerificationError(err error) bool {
+	switch err.(type) {
+	case *SignatureError, *DigestError:
+		return true
 	}
+	return false
+}
 
-	return
+// isCanceled reports whether err was caused by canceling the download.
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled)
 }
 
-func (d *Downloader) Cleanup() {
-	if d.tmpDir == "" {
-		return
+func indexOfFailure(errs []error) int {
+	for i, err := range errs {
+		if err != nil && !isCanceled(err) {
+			return i
+		}
+	}
+	return -1
+}
+
+// start derives a context for a single download which Stop can cancel. The
+// returned func must be called once the download is finished.
+func (d *Downloader) start(ctx context.Context) (context.Context, func(), error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return nil, nil, ErrStopped
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	id := d.nextID
+	d.nextID++
+	d.cancels[id] = cancel
+	return ctx, func() {
+		d.mu.Lock()
+		delete(d.cancels, id)
+		d.mu.Unlock()
+		cancel()
+	}, nil
+}
+
+// stopErr explains why ctx was canceled.
+func (d *Downloader) stopErr(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return ErrStopped
+	}
+	return ctx.Err()
+}
+
+func (d *Downloader) semaphore() chan struct{} {
+	n := d.Parallelism
+	if n <= 0 {
+		n = DefaultParallelism
 	}
-	os.RemoveAll(d.tmpDir)
+	return make(chan struct{}, n)
+}
+
+// progress serializes calls to d.Progress.
+func (d *Downloader) progress(p Progress) {
+	d.progressMu.Lock()
+	defer d.progressMu.Unlock()
+	d.Progress(p)
+}
+
+func (d *Downloader) imagePath(file string) string {
+	return path.Join(d.ImageDirectory, imageFileName(d.Provider, d.Channel, d.Board, d.Version)+"."+file)
+}
+
+func (d *Downloader) stagingDir() string {
+	return path.Join(d.ImageDirectory, stagingDirectory, imageFileName(d.Provider, d.Channel, d.Board, d.Version))
+}
+
+// Cleanup removes any partial downloads for this version.
+func (d *Downloader) Cleanup() {
+	os.RemoveAll(d.stagingDir())
 }
 
+// Stop aborts any download in progress and makes later calls to Download
+// fail with ErrStopped. Partial downloads are kept so they can be resumed.
+// Stop returns without waiting; Download returns once its files are closed.
 func (d *Downloader) Stop() {
-	d.stop <- struct{}{}
-	d.Cleanup()
-	// Wait until cleanup is finished
-	<-d.stopDone
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopped = true
+	for _, cancel := range d.cancels {
+		cancel()
+	}
 }
 
 type DownloadResult struct {
 	FileLocation      string
 	SignatureLocation string
+	// Digests of the file, checked against the published ones if the
+	// release has any.
+	Digests Digests
+}
+
+// remove deletes the downloaded files along with anything kept to resume
+// them.
+func (res DownloadResult) remove() {
+	for _, name := range []string{res.FileLocation, res.SignatureLocation} {
+		if name == "" {
+			continue
+		}
+		os.Remove(name)
+		os.Remove(name + validatorSuffix)
+	}
+}
+
+// Fetch downloads file and its signature into directory, verifies the
+// signature against the downloader's keyring and checks the file against the
+// published digests, without moving them into the image directory.
+func (d *Downloader) Fetch(ctx context.Context, file, directory string) (DownloadResult, error) {
+	return d.fetch(ctx, d.semaphore(), file, directory)
 }
 
-func Download(channel, version, file, imageDirectory string) (res DownloadResult, err error) {
-	plog.Infof("Downloading %s, Channel: %s, Version: %s", file, channel, version)
+// fetch downloads file, its signature and its digests at the same time,
+// holding a slot in sem for each request.
+func (d *Downloader) fetch(ctx context.Context, sem chan struct{}, file, directory string) (res DownloadResult, err error) {
+	plog.Infof("Downloading %s, Channel: %s, Board: %s, Version: %s", file, d.Channel, orDefaultBoard(d.Board), d.Version)
 
 	// The actual file
-	url := getFileURL(channel, version, file)
-	res.FileLocation = path.Join(imageDirectory, file)
+	name := path.Join(d.Version, file)
+	res.FileLocation = path.Join(directory, file)
 
 	// The signature file for our download
-	sigUrl := url + ".sig"
-	res.SignatureLocation = path.Join(imageDirectory, file+".sig")
+	sigName := name + ".sig"
+	res.SignatureLocation = path.Join(directory, file+".sig")
 
-	// Download both the file and it's signature
-	plog.Debugf("Downloading %s to %s", url, res.FileLocation)
-	err = get(url, res.FileLocation)
-	if err != nil {
-		return
+	var progress ProgressFunc
+	if d.Progress != nil {
+		progress = d.progress
 	}
 
-	plog.Debugf("Downloading %s to %s", sigUrl, res.SignatureLocation)
-	err = get(sigUrl, res.SignatureLocation)
+	// Download the file, it's signature and digests. The file is hashed as
+	// it downloads.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	digest := newDigester()
+	var published Digests
+	var fileErr, sigErr, digestsErr error
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		plog.Debugf("Downloading %s to %s", name, res.FileLocation)
+		fileErr = d.limit(ctx, sem, func() error {
+			return d.client().download(ctx, d.Channel, d.Board, name, res.FileLocation, progress, digest)
+		})
+		if fileErr != nil {
+			cancel()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		plog.Debugf("Downloading %s to %s", sigName, res.SignatureLocation)
+		// a missing signature doesn't cancel the file, so a version that
+		// doesn't exist is reported as such
+		sigErr = d.limit(ctx, sem, func() error {
+			return d.client().download(ctx, d.Channel, d.Board, sigName, res.SignatureLocation, nil, nil)
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		digestsErr = d.limit(ctx, sem, func() error {
+			var err error
+			published, err = d.client().getDigests(ctx, d.Channel, d.Board, name)
+			return err
+		})
+	}()
+	wg.Wait()
+
+	if fileErr != nil && progress != nil {
+		progress(Progress{File: file, Failed: true})
+	}
+	if fileErr != nil && !isCanceled(fileErr) {
+		return res, releaseError(fileErr, ErrVersionNotFound, d.Channel, d.Version)
+	}
+	if sigErr != nil && !isCanceled(sigErr) {
+		return res, &SignatureError{File: res.FileLocation, Err: sigErr}
+	}
+	if digestsErr != nil && !isCanceled(digestsErr) {
+		return res, releaseError(digestsErr, ErrVersionNotFound, d.Channel, d.Version)
+	}
+	if ctx.Err() != nil {
+		return res, ctx.Err()
+	}
+	err = verify(d.Keyring, res.FileLocation, res.SignatureLocation)
 	if err != nil {
 		return
 	}
-	// TODO: verify signatures
-	err = verify(res.FileLocation, res.SignatureLocation)
-	if err != nil {
+	res.Digests = digest.Sum()
+	if published == nil {
+		plog.Warningf("No digests published for %s, relying on its signature", file)
 		return
 	}
-	// plog.Infof("Signature verified!")
-	plog.Warning("Not verifying signatures (yet).")
+	err = published.Check(res.FileLocation, res.Digests)
+	if err == nil {
+		plog.Infof("Digests of %s match", file)
+	}
 	return
 }
 
-func get(url, outputFile string) error {
-	resp, err := http.Get(url)
+// limit calls fn after waiting for a free slot in sem.
+func (d *Downloader) limit(ctx context.Context, sem chan struct{}, fn func() error) error {
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-sem }()
+	return fn()
+}
+
+func (d *Downloader) client() *Client {
+	if d.Client == nil {
+		return DefaultClient
+	}
+	return d.Client
+}
+
+// validatorSuffix is appended to the name of a partial download to store the
+// ETag or Last-Modified value used to make sure a resumed download is of the
+// same file.
+const validatorSuffix = ".validator"
+
+// get downloads url into outputFile. If outputFile already holds the start of
+// the download it is resumed with a Range request, falling back to
+// downloading the whole file again if the server ignores the range or the
+// file changed. Progress is reported to progress, if it isn't nil. The
+// request is abandoned if it makes no progress for timeout, unless timeout is
+// zero. Everything downloaded, including any resumed part, is written to
+// digest if it isn't nil.
+func get(ctx context.Context, url, outputFile string, progress ProgressFunc, timeout time.Duration, digest *digester) error {
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return err
 	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	idle := newIdleTimer(timeout, cancel)
+	defer idle.stop()
+	req = req.WithContext(ctx)
+
+	var offset int64
+	validatorFile := outputFile + validatorSuffix
+	if fi, err := os.Stat(outputFile); err == nil && fi.Size() > 0 {
+		validator, err := ioutil.ReadFile(validatorFile)
+		if err == nil && len(validator) > 0 {
+			offset = fi.Size()
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			req.Header.Set("If-Range", string(validator))
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return idle.err(url, err)
+	}
 	defer resp.Body.Close()
-	f, err := os.Create(outputFile)
+
+	flags := os.O_WRONLY | os.O_CREATE
+	total := resp.ContentLength
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return err
+		}
+		total = size
+		if start != offset {
+			return fmt.Errorf("server resumed %s at byte %d, wanted %d", url, start, offset)
+		}
+		plog.Infof("Resuming download of %s from byte %d", path.Base(outputFile), offset)
+		flags |= os.O_APPEND
+		if digest != nil {
+			digest.Reset()
+			if err := digest.readFrom(outputFile, offset); err != nil {
+				return err
+			}
+		}
+	case http.StatusOK:
+		if offset > 0 {
+			plog.Infof("Unable to resume download of %s, starting over", path.Base(outputFile))
+		}
+		offset = 0
+		flags |= os.O_TRUNC
+		err = saveValidator(validatorFile, resp.Header)
+		if err != nil {
+			return err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		_, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err == nil && size == offset {
+			// we already have all of it
+			if digest != nil {
+				digest.Reset()
+				if err := digest.readFrom(outputFile, -1); err != nil {
+					return err
+				}
+			}
+			if progress != nil {
+				progress(Progress{File: path.Base(outputFile), Done: size, Total: size, Complete: true})
+			}
+			return nil
+		}
+		plog.Infof("Unable to resume download of %s, starting over", path.Base(outputFile))
+		os.Remove(validatorFile)
+		os.Remove(outputFile)
+		// start over with a timer of its own, this one would cancel the
+		// new download once it runs out
+		idle.stop()
+		resp.Body.Close()
+		return get(parent, url, outputFile, progress, timeout, digest)
+	default:
+		return &statusError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	f, err := os.OpenFile(outputFile, flags, 0644)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	_, err = io.Copy(f, resp.Body)
+	writers := []io.Writer{f}
+	if digest != nil && offset == 0 {
+		digest.Reset()
+	}
+	if digest != nil {
+		writers = append(writers, digest)
+	}
+	var pw *progressWriter
+	if progress != nil {
+		pw = newProgressWriter(path.Base(outputFile), offset, total, progress)
+		writers = append(writers, pw)
+	}
+	n, err := io.Copy(io.MultiWriter(writers...), idle.reader(resp.Body))
 	if err != nil {
-		return err
+		return idle.err(url, err)
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return io.ErrUnexpectedEOF
+	}
+	if pw != nil {
+		pw.finish()
 	}
 	return nil
 }
+
+// saveValidator stores the value a later request should send in If-Range to
+// resume this response, or removes it if the response can't be resumed.
+func saveValidator(validatorFile string, header http.Header) error {
+	validator := header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		// weak ETags can't be used with If-Range
+		validator = header.Get("Last-Modified")
+	}
+	if validator == "" || header.Get("Accept-Ranges") == "none" {
+		err := os.Remove(validatorFile)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	}
+	return ioutil.WriteFile(validatorFile, []byte(validator), 0644)
+}
+
+// parseContentRange parses the start offset and complete length out of a
+// Content-Range header, either "bytes start-end/size" or "bytes */size". The
+// size is -1 if unknown.
+func parseContentRange(contentRange string) (start, size int64, err error) {
+	invalid := fmt.Errorf("invalid Content-Range %q", contentRange)
+	items := strings.SplitN(strings.TrimPrefix(contentRange, "bytes "), "/", 2)
+	if len(items) != 2 || !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, 0, invalid
+	}
+	rangeSpec, sizeSpec := items[0], items[1]
+
+	size = -1
+	if sizeSpec != "*" {
+		if size, err = parseByteCount(sizeSpec); err != nil {
+			return 0, 0, invalid
+		}
+	}
+	if rangeSpec == "*" {
+		if size < 0 {
+			return 0, 0, invalid
+		}
+		return 0, size, nil
+	}
+	bounds := strings.SplitN(rangeSpec, "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, invalid
+	}
+	start, err = parseByteCount(bounds[0])
+	if err != nil {
+		return 0, 0, invalid
+	}
+	end, err := parseByteCount(bounds[1])
+	if err != nil || end < start || size >= 0 && end >= size {
+		return 0, 0, invalid
+	}
+	return start, size, nil
+}
+
+// parseByteCount parses a byte offset or length of a Content-Range header,
+// which is only ever digits.
+func parseByteCount(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid byte count %q", s)
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
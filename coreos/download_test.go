@@ -0,0 +1,56 @@
+package coreos
+
+import "testing"
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header string
+		start  int64
+		size   int64
+		err    bool
+	}{
+		{header: "bytes 0-99/100", start: 0, size: 100},
+		{header: "bytes 42-99/100", start: 42, size: 100},
+		{header: "bytes 99-99/100", start: 99, size: 100},
+		{header: "bytes 42-99/*", start: 42, size: -1},
+		{header: "bytes */100", start: 0, size: 100},
+		{header: "bytes */0", start: 0, size: 0},
+		{header: "bytes 0-9223372036854775806/9223372036854775807", start: 0, size: 9223372036854775807},
+
+		{header: "", err: true},
+		{header: "bytes", err: true},
+		{header: "bytes ", err: true},
+		{header: "42-99/100", err: true},
+		{header: "items 42-99/100", err: true},
+		{header: "bytes=42-99/100", err: true},
+		{header: "bytes */*", err: true},
+		{header: "bytes */", err: true},
+		{header: "bytes */-1", err: true},
+		{header: "bytes */+100", err: true},
+		{header: "bytes */1e3", err: true},
+		{header: "bytes 42-99", err: true},
+		{header: "bytes 42/100", err: true},
+		{header: "bytes 42-/100", err: true},
+		{header: "bytes -99/100", err: true},
+		{header: "bytes -1-99/100", err: true},
+		{header: "bytes +42-99/100", err: true},
+		{header: "bytes 99-42/100", err: true},
+		{header: "bytes 42-100/100", err: true},
+		{header: "bytes 0-0/0", err: true},
+		{header: "bytes 42-99-100/200", err: true},
+		{header: "bytes  42-99/100", err: true},
+		{header: "bytes 0-99/9223372036854775808", err: true},
+	}
+	for _, tt := range tests {
+		start, size, err := parseContentRange(tt.header)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseContentRange(%q) = %d, %d, expected an error", tt.header, start, size)
+			}
+			continue
+		}
+		if err != nil || start != tt.start || size != tt.size {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v, expected %d, %d", tt.header, start, size, err, tt.start, tt.size)
+		}
+	}
+}
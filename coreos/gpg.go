@@ -1,7 +1,12 @@
 package coreos
 
 import (
+	"bufio"
+	"fmt"
+	"os"
 	"path"
+
+	"golang.org/x/crypto/openpgp"
 )
 
 // Image signing key: buildbot@coreos.com
@@ -117,16 +122,56 @@ Pg==
 -----END PGP PUBLIC KEY BLOCK-----
 `
 
-func verify(fileName string, sigFileName string) error {
+// SignatureError is returned when a downloaded file is missing its detached
+// signature, or the signature was not made by a trusted key.
+type SignatureError struct {
+	File string
+	Err  error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("bad signature for %s: %v", path.Base(e.File), e.Err)
+}
+
+func (e *SignatureError) Unwrap() error {
+	return e.Err
+}
+
+// verify checks the detached signature of fileName against every key in the
+// keyring.
+func verify(keyring *Keyring, fileName string, sigFileName string) error {
 	plog.Infof("Verifying signature of %s using signature file %s", path.Base(fileName), path.Base(sigFileName))
-	// pack, err := packet.Read(reader)
-	// if err != nil {
-	// 	return err
-	// }
+	keys, err := keyring.Entities()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return &SignatureError{File: fileName, Err: ErrNoTrustedKeys}
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	sigFile, err := os.Open(sigFileName)
+	if err != nil {
+		return &SignatureError{File: fileName, Err: err}
+	}
+	defer sigFile.Close()
 
-	// signature, ok := pack.(*packet.Signature)
-	// if !ok {
-	// 	return errors.New("not a valid signature file!")
-	// }
+	// Release signatures are binary, but accept armored ones as well
+	sig := bufio.NewReader(sigFile)
+	var signer *openpgp.Entity
+	if header, _ := sig.Peek(5); string(header) == "-----" {
+		signer, err = openpgp.CheckArmoredDetachedSignature(keys, file, sig)
+	} else {
+		signer, err = openpgp.CheckDetachedSignature(keys, file, sig)
+	}
+	if err != nil {
+		return &SignatureError{File: fileName, Err: err}
+	}
+	plog.Infof("Signature verified, signed by %016X", signer.PrimaryKey.KeyId)
 	return nil
 }
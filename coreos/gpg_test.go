@@ -0,0 +1,167 @@
+package coreos
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+var testKeyConfig = &packet.Config{RSABits: 1024}
+
+func newTestKey(t *testing.T, name string) *openpgp.Entity {
+	e, err := openpgp.NewEntity(name, "test", name+"@example.com", testKeyConfig)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	return e
+}
+
+// newTestKeyring returns a keyring in a temporary directory trusting key.
+func newTestKeyring(t *testing.T, dir string, key *openpgp.Entity) *Keyring {
+	var armored bytes.Buffer
+	if err := writeArmoredKey(&armored, key); err != nil {
+		t.Fatal(err)
+	}
+	k := NewKeyring(dir)
+	if _, err := k.Add(&armored, fmt.Sprintf("%X", key.PrimaryKey.Fingerprint)); err != nil {
+		t.Fatalf("unable to add test key: %v", err)
+	}
+	return k
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "core-gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	trusted := newTestKey(t, "trusted")
+	unknown := newTestKey(t, "unknown")
+	keyring := newTestKeyring(t, dir, trusted)
+
+	data := []byte("coreos_production_pxe.vmlinuz")
+	file := path.Join(dir, "vmlinuz")
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sign := func(signer *openpgp.Entity, armored bool, message []byte) []byte {
+		var sig bytes.Buffer
+		var err error
+		if armored {
+			err = openpgp.ArmoredDetachSign(&sig, signer, bytes.NewReader(message), nil)
+		} else {
+			err = openpgp.DetachSign(&sig, signer, bytes.NewReader(message), nil)
+		}
+		if err != nil {
+			t.Fatalf("unable to sign: %v", err)
+		}
+		return sig.Bytes()
+	}
+
+	tests := []struct {
+		name string
+		sig  []byte
+		// want is the error the SignatureError wraps, nil for none
+		want error
+		// bad is set when the signature shouldn't verify, for errors
+		// without a value to compare to
+		bad bool
+	}{
+		{name: "good", sig: sign(trusted, false, data)},
+		{name: "armored", sig: sign(trusted, true, data)},
+		{name: "bad", sig: sign(trusted, false, []byte("something else")), bad: true},
+		{name: "bad armored", sig: sign(trusted, true, []byte("something else")), bad: true},
+		{name: "unknown key", sig: sign(unknown, false, data), want: pgperrors.ErrUnknownIssuer},
+		{name: "unknown key armored", sig: sign(unknown, true, data), want: pgperrors.ErrUnknownIssuer},
+		{name: "garbage", sig: []byte("not a signature"), bad: true},
+	}
+	for _, tt := range tests {
+		sigFile := file + ".sig"
+		if err := ioutil.WriteFile(sigFile, tt.sig, 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := verify(keyring, file, sigFile)
+		if tt.want == nil && !tt.bad {
+			if err != nil {
+				t.Errorf("%s: verify failed: %v", tt.name, err)
+			}
+			continue
+		}
+		var sigErr *SignatureError
+		if !errors.As(err, &sigErr) {
+			t.Errorf("%s: got %v, expected a SignatureError", tt.name, err)
+			continue
+		}
+		if sigErr.File != file {
+			t.Errorf("%s: SignatureError is for %s, expected %s", tt.name, sigErr.File, file)
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, expected %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyMissingSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "core-gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyring := newTestKeyring(t, dir, newTestKey(t, "trusted"))
+	file := path.Join(dir, "vmlinuz")
+	if err := ioutil.WriteFile(file, []byte("vmlinuz"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = verify(keyring, file, file+".sig")
+	var sigErr *SignatureError
+	if !errors.As(err, &sigErr) || !os.IsNotExist(sigErr.Err) {
+		t.Errorf("got %v, expected a SignatureError for the missing signature", err)
+	}
+}
+
+func TestKeyringPerProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "core-gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := newTestKey(t, "mirror")
+	fingerprint := fmt.Sprintf("%X", key.PrimaryKey.Fingerprint)
+	mirror := NewKeyring(dir)
+	mirror.Provider = &Distro{ID: "mirror"}
+	var armored bytes.Buffer
+	if err := writeArmoredKey(&armored, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mirror.Add(&armored, fingerprint); err != nil {
+		t.Fatalf("unable to add test key: %v", err)
+	}
+	if _, err := os.Stat(path.Join(dir, keyringDirectory, "mirror", fingerprint+".asc")); err != nil {
+		t.Errorf("key isn't stored in the provider's directory: %v", err)
+	}
+
+	if _, err := mirror.Get(fingerprint); err != nil {
+		t.Errorf("key isn't trusted for the provider it was added for: %v", err)
+	}
+	for _, p := range []ReleaseProvider{nil, Flatcar} {
+		other := NewKeyring(dir)
+		other.Provider = p
+		if _, err := other.Get(fingerprint); err == nil {
+			t.Errorf("key added for mirror is trusted for %s", orDefaultProvider(p).Name())
+		}
+		if _, err := other.Remove(fingerprint); err == nil {
+			t.Errorf("key added for mirror was removed from %s", orDefaultProvider(p).Name())
+		}
+	}
+}
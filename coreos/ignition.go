@@ -0,0 +1,205 @@
+package coreos
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// IgnitionSpecs are the stable versions of the Ignition config spec.
+var IgnitionSpecs = []string{
+	"2.0.0", "2.1.0", "2.2.0", "2.3.0",
+	"3.0.0", "3.1.0", "3.2.0", "3.3.0", "3.4.0",
+}
+
+// IgnitionSupport is how the releases of a distribution from MinVersion on
+// boot with Ignition.
+type IgnitionSupport struct {
+	// MinVersion is the first release this applies to.
+	MinVersion string `json:"min_version"`
+	// Specs are the versions of the config spec the releases accept.
+	Specs []string `json:"specs"`
+	// ConfigArg is the kernel parameter giving the URL of the config, and
+	// FirstBootArg the one that makes Ignition run.
+	ConfigArg    string `json:"config_arg"`
+	FirstBootArg string `json:"first_boot_arg"`
+}
+
+// Accepts reports whether the releases accept configs of spec version spec.
+func (s IgnitionSupport) Accepts(spec string) bool {
+	for _, v := range s.Specs {
+		if v == spec {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrNoIgnition is returned for releases that can't boot with Ignition.
+var ErrNoIgnition = errors.New("Ignition isn't supported")
+
+// ignitionSupport returns the entry of support for version, the last one
+// whose MinVersion it has reached. A version that isn't a release version,
+// as custom images have, is taken to be the newest.
+func ignitionSupport(support []IgnitionSupport, distro, version string) (IgnitionSupport, error) {
+	if len(support) == 0 {
+		return IgnitionSupport{}, fmt.Errorf("%w by %s", ErrNoIgnition, distro)
+	}
+	v, err := ParseVersion(version)
+	if err != nil {
+		return support[len(support)-1], nil
+	}
+	for i := len(support) - 1; i >= 0; i-- {
+		min, err := ParseVersion(support[i].MinVersion)
+		if err == nil && v.Compare(min) >= 0 {
+			return support[i], nil
+		}
+	}
+	return IgnitionSupport{}, fmt.Errorf("%w by %s %s, it was added in %s", ErrNoIgnition, distro, version, support[0].MinVersion)
+}
+
+// IsIgnition reports whether data looks like an Ignition config, a JSON
+// object, rather than user data for coreos-cloudinit.
+func IsIgnition(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+// ValidateIgnition checks that data is an Ignition config of a spec version
+// support accepts, and that its keys are those of that spec. Only the layout
+// of the config is checked, not every value. If support is nil, any stable
+// spec version is accepted.
+func ValidateIgnition(data []byte, support *IgnitionSupport) ConfigProblems {
+	if strings.HasPrefix(string(data), cloudConfigHeader) {
+		return ConfigProblems{{Line: 1, Severity: SeverityError, Message: "this is a cloud-config, not an Ignition config"}}
+	}
+	var config interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		var syntaxErr *json.SyntaxError
+		line := 0
+		if errors.As(err, &syntaxErr) {
+			line = 1 + bytes.Count(data[:syntaxErr.Offset], []byte("\n"))
+		}
+		return ConfigProblems{{Line: line, Severity: SeverityError, Message: "invalid JSON: " + err.Error()}}
+	}
+
+	// JSON is YAML, which keeps track of lines. Valid JSON only has tabs
+	// between tokens, where YAML doesn't always allow them.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(bytes.Replace(data, []byte("\t"), []byte(" "), -1), &doc); err != nil || len(doc.Content) == 0 {
+		return ConfigProblems{{Severity: SeverityWarning, Message: fmt.Sprintf("unable to check the layout of the config: %v", err)}}
+	}
+	root := doc.Content[0]
+	v := &validator{what: "an Ignition config"}
+	if root.Kind != yaml.MappingNode {
+		v.add(root.Line, SeverityError, "%s has to be a JSON object", v.what)
+		return v.problems
+	}
+
+	spec, line := ignitionVersion(root)
+	var major, minor int
+	switch {
+	case spec == "":
+		v.add(line, SeverityError, "ignition.version is missing, it's the version of the config spec, such as 3.3.0")
+		return v.problems
+	case !isIgnitionSpec(spec):
+		v.add(line, SeverityError, "ignition.version %q isn't a stable version of the config spec, they are %s", spec, strings.Join(IgnitionSpecs, ", "))
+		return v.problems
+	case support != nil && !support.Accepts(spec):
+		v.add(line, SeverityError, "the image doesn't accept version %s of the config spec, only %s", spec, strings.Join(support.Specs, ", "))
+	}
+	fmt.Sscanf(spec, "%d.%d", &major, &minor)
+	v.check(root, ignitionSchema(major, minor), "")
+	return v.sorted()
+}
+
+func isIgnitionSpec(spec string) bool {
+	for _, v := range IgnitionSpecs {
+		if v == spec {
+			return true
+		}
+	}
+	return false
+}
+
+// ignitionVersion returns the ignition.version of a config, and its line.
+func ignitionVersion(root *yaml.Node) (string, int) {
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "ignition" {
+			continue
+		}
+		ignition := root.Content[i+1]
+		for j := 0; j+1 < len(ignition.Content); j += 2 {
+			if ignition.Content[j].Value == "version" && ignition.Content[j+1].Kind == yaml.ScalarNode {
+				return ignition.Content[j+1].Value, ignition.Content[j+1].Line
+			}
+		}
+		return "", ignition.Line
+	}
+	return "", root.Line
+}
+
+// ignitionSchema is the layout of configs of spec version major.minor, down
+// to the lists of storage, units and users.
+func ignitionSchema(major, minor int) *schema {
+	node := func(required ...string) *schema {
+		return sequence(mapping(nil, required...))
+	}
+	dropins := sequence(mapping(map[string]*schema{
+		"name":     scalar(),
+		"contents": scalar(),
+	}, "name"))
+	ignition := map[string]*schema{
+		"version":  scalar(),
+		"timeouts": mapping(nil),
+		"security": mapping(nil),
+	}
+	storage := map[string]*schema{
+		"disks":       node("device"),
+		"raid":        node("name"),
+		"filesystems": node(),
+	}
+	units := map[string]*schema{
+		"name":     scalar(),
+		"enabled":  boolean(),
+		"mask":     boolean(),
+		"contents": scalar(),
+		"dropins":  dropins,
+	}
+	top := map[string]*schema{
+		"systemd": mapping(map[string]*schema{"units": sequence(mapping(units, "name"))}),
+		"passwd":  mapping(map[string]*schema{"users": node("name"), "groups": node("name")}),
+	}
+
+	if major == 2 {
+		ignition["config"] = mapping(map[string]*schema{"append": node(), "replace": mapping(nil)})
+		storage["files"] = node("filesystem", "path")
+		storage["directories"] = node("filesystem", "path")
+		storage["links"] = node("filesystem", "path", "target")
+		units["enable"] = boolean()
+		top["networkd"] = mapping(map[string]*schema{"units": node("name")})
+	} else {
+		ignition["config"] = mapping(map[string]*schema{"merge": node(), "replace": mapping(nil)})
+		storage["files"] = node("path")
+		storage["directories"] = node("path")
+		storage["links"] = node("path", "target")
+		if minor >= 1 {
+			ignition["proxy"] = mapping(nil)
+		}
+		if minor >= 2 {
+			storage["luks"] = node("name", "device")
+		}
+		if minor >= 3 {
+			top["kernelArguments"] = mapping(map[string]*schema{
+				"shouldExist":    sequence(scalar()),
+				"shouldNotExist": sequence(scalar()),
+			})
+		}
+	}
+	top["ignition"] = mapping(ignition, "version")
+	top["storage"] = mapping(storage)
+	return mapping(top, "ignition")
+}
@@ -2,78 +2,110 @@ package coreos
 
 import (
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"path"
-	"path/filepath"
-	"regexp"
-	"sort"
 	"strings"
 
 	"github.com/ecnahc515/core/xhyve"
 )
 
+// Vmlinuz and Initrd are the names of the CoreOS PXE kernel and initrd.
 const (
 	Vmlinuz               = "coreos_production_pxe.vmlinuz"
 	Initrd                = "coreos_production_pxe_image.cpio.gz"
 	DefaultImageDirectory = "$HOME/.core/images"
 )
 
-var (
-	re               = regexp.MustCompile("^alpha.([0-9.]+).coreos_production_pxe.vmlinuz$")
-	ErrNoLocalImages = errors.New("no local image files")
-)
+var ErrNoLocalImages = errors.New("no local image files")
 
+// GetLatestImage returns the newest version of channel in imageDirectory.
 func GetLatestImage(channel, imageDirectory string) (string, error) {
-	pat := fmt.Sprintf("%s.*.vmlinuz", channel)
-	names, err := filepath.Glob(path.Join(imageDirectory, pat))
+	versions, err := LocalVersions(nil, channel, DefaultBoard, imageDirectory)
 	if err != nil {
 		return "", err
 	}
-	if len(names) == 0 {
+	if len(versions) == 0 {
 		return "", ErrNoLocalImages
 	}
-	sort.Strings(names)
-	name := path.Base(names[len(names)-1])
-	matches := re.FindStringSubmatch(name)
-	if len(matches) != 2 {
-		return "", ErrNoLocalImages
+	return versions[len(versions)-1], nil
+}
+
+// LocalVersions returns the versions of channel for board with both a kernel
+// and an initrd of p in the image store in imageDirectory, oldest first. A
+// nil p is DefaultProvider.
+func LocalVersions(p ReleaseProvider, channel, board, imageDirectory string) ([]string, error) {
+	store, err := OpenStore(imageDirectory)
+	if err != nil {
+		return nil, err
 	}
-	return matches[1], nil
+	return store.Versions(p, channel, board), nil
 }
 
 type Config struct {
-	Version        string
-	Channel        string
-	Cmdline        string
-	SSHKey         string
-	CloudConfig    string
+	Version string
+	Channel string
+	Board   string
+	// Distro is the name of the release provider, DefaultProvider if empty.
+	Distro      string
+	Cmdline     string
+	SSHKey      string
+	CloudConfig string
+	// Ignition is the URL of an Ignition config.
+	Ignition       string
 	ImageDirectory string
 	Root           string
+	// Kernel and Initrd boot custom files instead of the image of Channel
+	// and Version.
+	Kernel string
+	Initrd string
 }
 
 func NewKernelConfig(cfg Config) (xhyve.KernelConfig, error) {
-	cmdline := "earlyprintk=serial console=ttyS0 coreos.autologin"
+	p, err := LookupProvider(cfg.Distro)
+	if err != nil {
+		return xhyve.KernelConfig{}, err
+	}
+	cmdline, err := xhyve.ParseCmdline(p.Cmdline())
+	if err != nil {
+		return xhyve.KernelConfig{}, err
+	}
 	if cfg.SSHKey != "" {
 		contents, err := ioutil.ReadFile(cfg.SSHKey)
 		if err != nil {
 			return xhyve.KernelConfig{}, err
 		}
-		sshkey := strings.TrimSpace(string(contents))
-		cmdline = fmt.Sprintf("%s sshkey=\"%s\"", cmdline, sshkey)
+		cmdline.Set("sshkey", strings.TrimSpace(string(contents)))
 	}
 	if cfg.CloudConfig != "" {
-		cmdline = fmt.Sprintf("%s cloud-config-url=%s", cmdline, cfg.CloudConfig)
+		cmdline.Set("cloud-config-url", cfg.CloudConfig)
+	}
+	if cfg.Ignition != "" {
+		ign, err := p.Ignition(cfg.Version)
+		if err != nil {
+			return xhyve.KernelConfig{}, err
+		}
+		cmdline.Set(ign.ConfigArg, cfg.Ignition)
+		cmdline.Set(ign.FirstBootArg, "1")
 	}
 	// TODO: support more disks and don't hardcode the location
 	if cfg.Root != "" {
-		cmdline = fmt.Sprintf("%s root=/dev/vda", cmdline)
+		cmdline.Set("root", "/dev/vda")
+	}
+	// the user's parameters override ours
+	user, err := xhyve.ParseCmdline(cfg.Cmdline)
+	if err != nil {
+		return xhyve.KernelConfig{}, err
 	}
-	cmdline = fmt.Sprintf("%s %s", cmdline, cfg.Cmdline)
+	cmdline.Override(user)
 
-	image := fmt.Sprintf("%s.%s.coreos_production_pxe", cfg.Channel, cfg.Version)
-	vmlinuz := path.Join(cfg.ImageDirectory, fmt.Sprintf("%s.vmlinuz", image))
-	initrd := path.Join(cfg.ImageDirectory, fmt.Sprintf("%s_image.cpio.gz", image))
+	vmlinuz, initrd := cfg.Kernel, cfg.Initrd
+	if vmlinuz == "" && initrd == "" {
+		image := imageFileName(p, cfg.Channel, cfg.Board, cfg.Version)
+		vmlinuz = path.Join(cfg.ImageDirectory, image+"."+p.Kernel())
+		initrd = path.Join(cfg.ImageDirectory, image+"."+p.Initrd())
+	} else if vmlinuz == "" || initrd == "" {
+		return xhyve.KernelConfig{}, errors.New("a custom kernel and initrd have to be given together")
+	}
 	return xhyve.KernelConfig{
 		Vmlinuz: vmlinuz,
 		Initrd:  initrd,
@@ -0,0 +1,295 @@
+package coreos
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ecnahc515/core/xhyve"
+)
+
+// ErrImageExists is returned when importing a release that is already in the
+// image directory.
+var ErrImageExists = errors.New("image already exists")
+
+// Importer adds a release to the image directory from local files, for
+// machines that can't reach a release server. Imported files are verified
+// against a detached signature or a DIGESTS file when one is provided, and
+// have their digests recorded like downloaded ones.
+type Importer struct {
+	Channel string
+	Version string
+	// Board of the release, DefaultBoard if empty.
+	Board          string
+	ImageDirectory string
+	// Provider whose file names are used, DefaultProvider if nil.
+	Provider ReleaseProvider
+	Keyring  *Keyring
+	// Force replaces a release that is already in the image directory.
+	Force bool
+}
+
+func NewImporter(channel, version, imageDirectory string) *Importer {
+	return &Importer{
+		Channel:        channel,
+		Version:        version,
+		ImageDirectory: imageDirectory,
+		Keyring:        NewKeyring(imageDirectory),
+	}
+}
+
+// Import copies kernel and initrd into the image directory. A detached
+// signature named <file>.sig or a DIGESTS file named <file>.DIGESTS next to
+// either file is used to verify it.
+func (im *Importer) Import(kernel, initrd string) error {
+	if !channelName.MatchString(im.Channel) {
+		return fmt.Errorf("invalid channel %q", im.Channel)
+	}
+	if _, err := ParseVersion(im.Version); err != nil {
+		return err
+	}
+	if err := ValidateBoard(orDefaultBoard(im.Board)); err != nil {
+		return err
+	}
+	if arch, err := xhyve.KernelArch(kernel); err != nil {
+		return err
+	} else if arch != "" && arch != BoardArch(orDefaultBoard(im.Board)) {
+		return fmt.Errorf("%s is an %s kernel, not %s", kernel, arch, orDefaultBoard(im.Board))
+	}
+	if err := xhyve.CheckInitrd(initrd); err != nil {
+		return err
+	}
+
+	p := orDefaultProvider(im.Provider)
+	lock, err := lockFetch(im.ImageDirectory, p, im.Channel, im.Board, im.Version)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	files := []string{p.Kernel(), p.Initrd()}
+	locs := make([]string, len(files))
+	exists := false
+	for i, file := range files {
+		locs[i] = path.Join(im.ImageDirectory, imageFileName(p, im.Channel, im.Board, im.Version)+"."+file)
+		if !inDirectory(im.ImageDirectory, locs[i]) {
+			return fmt.Errorf("%s would be outside the image directory", locs[i])
+		}
+		if _, err := os.Stat(locs[i]); err == nil {
+			if !im.Force {
+				return fmt.Errorf("%w: %s", ErrImageExists, path.Base(locs[i]))
+			}
+			exists = true
+		}
+	}
+	if exists {
+		inUse, err := lockImage(im.ImageDirectory, p, im.Channel, im.Board, im.Version, true)
+		if err != nil {
+			return err
+		}
+		defer inUse.Unlock()
+	}
+
+	stagingDir := path.Join(im.ImageDirectory, stagingDirectory)
+	if err := os.MkdirAll(stagingDir, 0700); err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir(stagingDir, imageFileName(p, im.Channel, im.Board, im.Version)+".import")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	staged := make([]DownloadResult, len(files))
+	verified := make(map[string]Verification)
+	for i, src := range []string{kernel, initrd} {
+		staged[i], verified[files[i]], err = im.stage(src, path.Join(dir, files[i]))
+		if err != nil {
+			return err
+		}
+	}
+	if im.Force {
+		for _, loc := range locs {
+			for _, name := range []string{loc, loc + ".sig", loc + digestsSuffix} {
+				os.Remove(name)
+			}
+		}
+	}
+	if err := install(staged, locs); err != nil {
+		return err
+	}
+	store, err := OpenStore(im.ImageDirectory)
+	if err == nil {
+		_, err = store.record(p, im.Channel, im.Board, im.Version, SourceImport, verified)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to add %s %s (%s) to the image index: %v", p.Name(), im.Channel, im.Version, err)
+	}
+	return nil
+}
+
+// inDirectory reports whether name is dir itself or inside it, once both are
+// cleaned.
+func inDirectory(dir, name string) bool {
+	dir, name = path.Clean(dir), path.Clean(name)
+	return name == dir || strings.HasPrefix(name, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// stage copies src to dst, along with its signature, and verifies it.
+func (im *Importer) stage(src, dst string) (DownloadResult, Verification, error) {
+	res := DownloadResult{FileLocation: dst}
+	digest := newDigester()
+	if err := copyFile(src, dst, digest); err != nil {
+		return res, "", err
+	}
+	res.Digests = digest.Sum()
+
+	verified := Unverified
+	if _, err := os.Stat(src + ".sig"); err == nil {
+		res.SignatureLocation = dst + ".sig"
+		if err := copyFile(src+".sig", res.SignatureLocation, nil); err != nil {
+			return res, "", err
+		}
+		if err := verify(im.Keyring, dst, res.SignatureLocation); err != nil {
+			return res, "", fmt.Errorf("%s: %w", src, err)
+		}
+		verified = VerifiedSignature
+	}
+	if f, err := os.Open(src + digestsSuffix); err == nil {
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return res, "", err
+		}
+		// the DIGESTS file may list the file by its name in the release or
+		// by the name it was imported from
+		published, err := ParseDigests(bytes.NewReader(data), path.Base(dst))
+		if err != nil {
+			published, err = ParseDigests(bytes.NewReader(data), path.Base(src))
+		}
+		if err != nil {
+			return res, "", err
+		}
+		if err := published.Check(src, res.Digests); err != nil {
+			return res, "", err
+		}
+		plog.Infof("Digests of %s match", path.Base(src))
+		if verified == Unverified {
+			verified = VerifiedDigests
+		}
+	}
+	if verified == Unverified {
+		plog.Warningf("No signature or digests found for %s, importing it unverified", src)
+	}
+	return res, verified, nil
+}
+
+// ImportArchive imports the kernel and initrd from a tar archive, which may
+// be gzip compressed. The archive holds one file ending in vmlinuz and one
+// ending in cpio.gz, and optionally their signatures and DIGESTS files.
+func (im *Importer) ImportArchive(name string) error {
+	tr, closer, err := openTar(name)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	stagingDir := path.Join(im.ImageDirectory, stagingDirectory)
+	if err := os.MkdirAll(stagingDir, 0700); err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir(stagingDir, "archive")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var kernel, initrd string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %v", name, err)
+		}
+		base := path.Base(hdr.Name)
+		file := strings.TrimSuffix(strings.TrimSuffix(base, ".sig"), digestsSuffix)
+		if hdr.Typeflag != tar.TypeReg || (!strings.HasSuffix(file, "vmlinuz") && !strings.HasSuffix(file, ".cpio.gz")) {
+			continue
+		}
+		if base == file {
+			found, what := &kernel, "kernel"
+			if strings.HasSuffix(file, ".cpio.gz") {
+				found, what = &initrd, "initrd"
+			}
+			if *found != "" {
+				return fmt.Errorf("%s holds more than one %s", name, what)
+			}
+			*found = path.Join(dir, file)
+		}
+		out, err := os.OpenFile(path.Join(dir, base), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if kernel == "" || initrd == "" {
+		return fmt.Errorf("%s must hold a kernel ending in vmlinuz and an initrd ending in cpio.gz", name)
+	}
+	return im.Import(kernel, initrd)
+}
+
+// openTar opens the tar archive name, which may be gzip compressed. The
+// returned closer closes the file.
+func openTar(name string) (*tar.Reader, io.Closer, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReader(f)
+	if magic, _ := r.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return tar.NewReader(gz), f, nil
+	}
+	return tar.NewReader(r), f, nil
+}
+
+// copyFile copies src to dst, hashing it into digest if that isn't nil.
+func copyFile(src, dst string, digest *digester) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = out
+	if digest != nil {
+		w = io.MultiWriter(out, digest)
+	}
+	_, err = io.Copy(w, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+package coreos
+
+import (
+	"github.com/ecnahc515/core/xhyve"
+)
+
+// Inspection describes the kernel and initrd an image boots.
+type Inspection struct {
+	Vmlinuz string             `json:"vmlinuz"`
+	Kernel  xhyve.KernelHeader `json:"kernel"`
+	Initrd  string             `json:"initrd"`
+	Image   xhyve.InitrdInfo   `json:"image"`
+}
+
+// Inspect reads the kernel header and initrd contents of the image cfg
+// boots, the files NewKernelConfig would give to xhyve.
+func Inspect(cfg Config) (Inspection, error) {
+	kernelCfg, err := NewKernelConfig(cfg)
+	if err != nil {
+		return Inspection{}, err
+	}
+	in := Inspection{Vmlinuz: kernelCfg.Vmlinuz, Initrd: kernelCfg.Initrd}
+	if in.Kernel, err = xhyve.ReadKernelHeader(kernelCfg.Vmlinuz); err != nil {
+		return in, err
+	}
+	if in.Image, err = xhyve.InspectInitrd(kernelCfg.Initrd); err != nil {
+		return in, err
+	}
+	return in, nil
+}
@@ -0,0 +1,331 @@
+package coreos
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+const keyringDirectory = "keys"
+
+var (
+	ErrKeyNotFound = errors.New("no such key in keyring")
+	ErrBuiltinKey  = errors.New("the built in signing key cannot be removed")
+	// ErrInvalidKeyID is returned for a key ID that isn't a full fingerprint,
+	// a long ID or a short ID.
+	ErrInvalidKeyID = errors.New("not a fingerprint, long key ID or short key ID")
+	// ErrAmbiguousKeyID is returned for a key ID more than one key has.
+	ErrAmbiguousKeyID = errors.New("key ID matches more than one key")
+	// ErrNoTrustedKeys is returned when verifying a signature without any
+	// keys to check it against.
+	ErrNoTrustedKeys = errors.New("no keys are trusted to sign releases")
+)
+
+// Keyring is the set of keys trusted to sign a provider's images. It always
+// contains the built in keys of its provider, such as the CoreOS buildbot key,
+// plus any keys added for the provider, which are kept in a directory named
+// after it in the keys directory inside the image directory. Keys are stored
+// armored, one per file, named by their fingerprint.
+type Keyring struct {
+	Directory string
+	// Provider whose built in keys are trusted, DefaultProvider if nil.
+	Provider ReleaseProvider
+}
+
+func NewKeyring(imageDirectory string) *Keyring {
+	return &Keyring{
+		Directory: path.Join(imageDirectory, keyringDirectory),
+	}
+}
+
+// KeyInfo describes a trusted key or one of its subkeys.
+type KeyInfo struct {
+	Fingerprint string
+	KeyID       string
+	Identities  []string
+	Created     time.Time
+	// Expires is the zero time if the key does not expire
+	Expires time.Time
+	CanSign bool
+	Builtin bool
+	Subkeys []KeyInfo
+}
+
+func (k KeyInfo) Expired(now time.Time) bool {
+	return !k.Expires.IsZero() && now.After(k.Expires)
+}
+
+func newKeyInfo(e *openpgp.Entity, builtin bool) KeyInfo {
+	var selfSig *packet.Signature
+	var names []string
+	for name, id := range e.Identities {
+		names = append(names, name)
+		if selfSig == nil || (id.SelfSignature.IsPrimaryId != nil && *id.SelfSignature.IsPrimaryId) {
+			selfSig = id.SelfSignature
+		}
+	}
+	info := keyInfo(e.PrimaryKey, selfSig)
+	info.Builtin = builtin
+	info.Identities = names
+	sort.Strings(info.Identities)
+	for _, sub := range e.Subkeys {
+		info.Subkeys = append(info.Subkeys, keyInfo(sub.PublicKey, sub.Sig))
+	}
+	return info
+}
+
+func keyInfo(key *packet.PublicKey, sig *packet.Signature) KeyInfo {
+	info := KeyInfo{
+		Fingerprint: fmt.Sprintf("%X", key.Fingerprint),
+		KeyID:       fmt.Sprintf("%016X", key.KeyId),
+		Created:     key.CreationTime,
+		CanSign:     key.CanSign(),
+	}
+	if sig != nil {
+		if sig.KeyLifetimeSecs != nil && *sig.KeyLifetimeSecs != 0 {
+			lifetime := time.Duration(*sig.KeyLifetimeSecs) * time.Second
+			info.Expires = key.CreationTime.Add(lifetime)
+		}
+		if sig.FlagsValid {
+			info.CanSign = sig.FlagSign
+		}
+	}
+	return info
+}
+
+// NormalizeFingerprint strips whitespace and an optional 0x prefix from a
+// fingerprint or key ID and upper cases it.
+func NormalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.Join(strings.Fields(fingerprint), "")
+	fingerprint = strings.TrimPrefix(strings.ToLower(fingerprint), "0x")
+	return strings.ToUpper(fingerprint)
+}
+
+// providerDirectory is where the keys added for k's provider are kept. Each
+// provider has its own, so a key added for one can't sign another's releases.
+func (k *Keyring) providerDirectory() string {
+	return path.Join(k.Directory, orDefaultProvider(k.Provider).Name())
+}
+
+func (k *Keyring) keyFile(fingerprint string) string {
+	return path.Join(k.providerDirectory(), fingerprint+".asc")
+}
+
+// Entities returns every trusted key, starting with the built in ones. A nil
+// Keyring trusts only the built in keys of DefaultProvider.
+func (k *Keyring) Entities() (openpgp.EntityList, error) {
+	keys, err := k.builtin()
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		return keys, nil
+	}
+	files, err := filepath.Glob(path.Join(k.providerDirectory(), "*.asc"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	for _, name := range files {
+		el, err := readArmoredKeyFile(name)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, el...)
+	}
+	return keys, nil
+}
+
+// readArmoredKeys reads the keys in every armored block in r.
+func readArmoredKeys(r io.Reader) (openpgp.EntityList, error) {
+	// armor.Decode reuses a big enough bufio.Reader rather than reading
+	// ahead into one of its own, leaving the next block to read
+	br := bufio.NewReader(r)
+	var keys openpgp.EntityList
+	for {
+		block, err := armor.Decode(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if block.Type != openpgp.PublicKeyType {
+			return nil, fmt.Errorf("expected a public key, found %s", block.Type)
+		}
+		el, err := openpgp.ReadKeyRing(block.Body)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, el...)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no armored keys found")
+	}
+	return keys, nil
+}
+
+func (k *Keyring) builtin() (openpgp.EntityList, error) {
+	if k == nil {
+		return DefaultProvider.Keys()
+	}
+	return orDefaultProvider(k.Provider).Keys()
+}
+
+func readArmoredKeyFile(name string) (openpgp.EntityList, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	el, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key %s: %v", path.Base(name), err)
+	}
+	return el, nil
+}
+
+// List returns information about every trusted key.
+func (k *Keyring) List() ([]KeyInfo, error) {
+	builtin, err := k.builtin()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := k.Entities()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]KeyInfo, len(keys))
+	for i, e := range keys {
+		infos[i] = newKeyInfo(e, i < len(builtin))
+	}
+	return infos, nil
+}
+
+// Get finds a trusted key by its full fingerprint, its 16 digit long key ID or
+// its 8 digit short key ID. It's an error wrapping ErrAmbiguousKeyID if more
+// than one key has the ID.
+func (k *Keyring) Get(id string) (KeyInfo, error) {
+	id = NormalizeFingerprint(id)
+	if !isKeyID(id) {
+		return KeyInfo{}, fmt.Errorf("%q is %w", id, ErrInvalidKeyID)
+	}
+	infos, err := k.List()
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	var matches []KeyInfo
+	for _, info := range infos {
+		if strings.HasSuffix(info.Fingerprint, id) {
+			matches = append(matches, info)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return KeyInfo{}, ErrKeyNotFound
+	case 1:
+		return matches[0], nil
+	}
+	fingerprints := make([]string, len(matches))
+	for i, info := range matches {
+		fingerprints[i] = info.Fingerprint
+	}
+	return KeyInfo{}, fmt.Errorf("%w %s: %s, give the full fingerprint", ErrAmbiguousKeyID, id, strings.Join(fingerprints, ", "))
+}
+
+// isKeyID reports whether id, normalized, is a full fingerprint, a long key
+// ID or a short key ID.
+func isKeyID(id string) bool {
+	switch len(id) {
+	case 40, 16, 8:
+	default:
+		return false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune("0123456789ABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Add imports the armored public key with the given fingerprint from r. Any
+// other keys in r are ignored, and it is an error if r does not contain a key
+// with that exact fingerprint.
+func (k *Keyring) Add(r io.Reader, fingerprint string) (KeyInfo, error) {
+	fingerprint = NormalizeFingerprint(fingerprint)
+	if len(fingerprint) != 40 {
+		return KeyInfo{}, fmt.Errorf("%q is not a full key fingerprint", fingerprint)
+	}
+	el, err := openpgp.ReadArmoredKeyRing(r)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	var entity *openpgp.Entity
+	for _, e := range el {
+		if fmt.Sprintf("%X", e.PrimaryKey.Fingerprint) == fingerprint {
+			entity = e
+			break
+		}
+	}
+	if entity == nil {
+		return KeyInfo{}, fmt.Errorf("no key with fingerprint %s found", fingerprint)
+	}
+	if existing, err := k.Get(fingerprint); err == nil && existing.Builtin {
+		return KeyInfo{}, fmt.Errorf("key %s is already built in", fingerprint)
+	}
+
+	if err := os.MkdirAll(k.providerDirectory(), 0700); err != nil {
+		return KeyInfo{}, err
+	}
+	tmp, err := ioutil.TempFile(k.providerDirectory(), ".import")
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+	err = writeArmoredKey(tmp, entity)
+	tmp.Close()
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	if err := os.Rename(tmp.Name(), k.keyFile(fingerprint)); err != nil {
+		return KeyInfo{}, err
+	}
+	return newKeyInfo(entity, false), nil
+}
+
+func writeArmoredKey(w io.Writer, e *openpgp.Entity) error {
+	aw, err := armor.Encode(w, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return err
+	}
+	if err := e.Serialize(aw); err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// Remove removes the key with the given fingerprint or key ID from the
+// keyring.
+func (k *Keyring) Remove(id string) (KeyInfo, error) {
+	info, err := k.Get(id)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	if info.Builtin {
+		return KeyInfo{}, ErrBuiltinKey
+	}
+	return info, os.Remove(k.keyFile(info.Fingerprint))
+}
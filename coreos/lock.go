@@ -0,0 +1,119 @@
+package coreos
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+	"time"
+)
+
+// LockTimeout is how long to wait for another process to release a lock on
+// the image directory before giving up.
+var LockTimeout = 5 * time.Minute
+
+var (
+	// ErrLockTimeout is returned when a lock isn't released within
+	// LockTimeout.
+	ErrLockTimeout = errors.New("timed out waiting for lock")
+	// ErrImageInUse is returned when removing or replacing an image that a
+	// running VM is using.
+	ErrImageInUse = errors.New("image is in use")
+)
+
+// locksDirectory holds the lock files, inside the image directory.
+const locksDirectory = ".locks"
+
+// FileLock is an advisory lock on a file, held with flock(2). It's released
+// when the process exits, even if Unlock isn't called.
+type FileLock struct {
+	f *os.File
+}
+
+// lockFile locks name, creating it if needed, waiting up to timeout for other
+// processes to release it. A timeout of 0 doesn't wait at all. what describes
+// the lock in log messages.
+func lockFile(name, what string, exclusive bool, timeout time.Duration) (*FileLock, error) {
+	if err := os.MkdirAll(path.Dir(name), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	deadline := time.Now().Add(timeout)
+	wait := 10 * time.Millisecond
+	for waited := false; ; waited = true {
+		err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			return &FileLock{f: f}, nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, fmt.Errorf("unable to lock %s: %v", what, err)
+		}
+		if !time.Now().Before(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("%w on %s", ErrLockTimeout, what)
+		}
+		if !waited && timeout > time.Second {
+			plog.Infof("Waiting for another process to release %s", what)
+		}
+		time.Sleep(minDuration(wait, time.Until(deadline)))
+		wait = minDuration(wait*2, 250*time.Millisecond)
+	}
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Unlock releases the lock.
+func (l *FileLock) Unlock() error {
+	err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// lockIndex locks the index of the store in directory. Changes to it are
+// made under an exclusive lock.
+func lockIndex(directory string, exclusive bool) (*FileLock, error) {
+	return lockFile(path.Join(directory, locksDirectory, indexFile+".lock"), "the image index", exclusive, LockTimeout)
+}
+
+// lockImage locks the files of an image of p. Running VMs hold a shared
+// lock on the image they boot, and its files are only removed or replaced
+// under an exclusive one, which isn't waited for: it's ErrImageInUse if a VM
+// holds the lock.
+func lockImage(directory string, p ReleaseProvider, channel, board, version string, exclusive bool) (*FileLock, error) {
+	name := orDefaultProvider(p).Name() + "." + imageName(channel, board, version)
+	what := fmt.Sprintf("%s %s %s (%s)", orDefaultProvider(p).Name(), channel, version, orDefaultBoard(board))
+	timeout := LockTimeout
+	if exclusive {
+		timeout = 0
+	}
+	lock, err := lockFile(path.Join(directory, locksDirectory, name+".lock"), what, exclusive, timeout)
+	if exclusive && errors.Is(err, ErrLockTimeout) {
+		return nil, fmt.Errorf("%w: %s", ErrImageInUse, what)
+	}
+	return lock, err
+}
+
+// lockFetch serializes downloads and imports of an image, so that only one
+// process at a time checks whether it's already in the image directory and
+// moves its files into place.
+func lockFetch(directory string, p ReleaseProvider, channel, board, version string) (*FileLock, error) {
+	name := orDefaultProvider(p).Name() + "." + imageName(channel, board, version)
+	what := fmt.Sprintf("the download of %s %s (%s)", channel, version, orDefaultBoard(board))
+	return lockFile(path.Join(directory, locksDirectory, name+".fetch.lock"), what, true, LockTimeout)
+}
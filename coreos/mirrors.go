@@ -0,0 +1,68 @@
+package coreos
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// DefaultReleaseURL is where CoreOS releases are downloaded from. {channel}
+// is replaced by the release channel and {board} by the board.
+const DefaultReleaseURL = "http://{channel}.release.core-os.net/{board}"
+
+// Mirrors is an ordered list of release URL templates, in the same form as
+// DefaultReleaseURL. Each file is requested from the first mirror, moving on
+// to the next one if it can't be reached or responds with a server error.
+// Templates without {board} only serve DefaultBoard.
+type Mirrors []string
+
+// DefaultMirrors only uses DefaultReleaseURL.
+var DefaultMirrors = Mirrors{DefaultReleaseURL}
+
+// Validate checks that every mirror is an absolute http or https URL.
+func (m Mirrors) Validate() error {
+	for _, mirror := range m {
+		u, err := url.Parse(expandReleaseURL(mirror, "channel", DefaultBoard))
+		if err != nil {
+			return fmt.Errorf("invalid release URL %q: %v", mirror, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid release URL %q: must be an http or https URL", mirror)
+		}
+	}
+	return nil
+}
+
+func (m Mirrors) orDefault() Mirrors {
+	if len(m) == 0 {
+		return DefaultMirrors
+	}
+	return m
+}
+
+func expandReleaseURL(template, channel, board string) string {
+	r := strings.NewReplacer("{channel}", channel, "{board}", board)
+	return r.Replace(strings.TrimSuffix(template, "/"))
+}
+
+// urls returns the URL of name, a path relative to the release root of
+// channel and board, on every mirror that serves board, in order.
+func (m Mirrors) urls(channel, board, name string) []string {
+	var urls []string
+	board = orDefaultBoard(board)
+	for _, mirror := range m.orDefault() {
+		if board != DefaultBoard && !strings.Contains(mirror, "{board}") {
+			continue
+		}
+		urls = append(urls, expandReleaseURL(mirror, channel, board)+"/"+name)
+	}
+	return urls
+}
+
+func hostOf(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return parsed.Host
+}
@@ -0,0 +1,84 @@
+package coreos
+
+import (
+	"time"
+)
+
+// progressInterval is how often a download in progress is reported.
+const progressInterval = 200 * time.Millisecond
+
+// Progress describes how far along the download of a single file is.
+type Progress struct {
+	File string
+	// Done is the number of bytes downloaded, including any resumed from a
+	// previous attempt.
+	Done int64
+	// Total is the size of the file, or -1 if the server didn't say.
+	Total int64
+	// Rate is the download speed in bytes per second.
+	Rate float64
+	// ETA is the estimated time left, or -1 if it can't be estimated.
+	ETA      time.Duration
+	Complete bool
+	// Failed is set, with only File, when the download failed or was
+	// canceled before it completed.
+	Failed bool
+}
+
+// ProgressFunc is called periodically while a file is downloading, and once
+// more with Complete set when it finishes, or Failed if it doesn't.
+type ProgressFunc func(Progress)
+
+// progressWriter counts the bytes written through it and reports them to a
+// ProgressFunc at most once every progressInterval.
+type progressWriter struct {
+	fn       ProgressFunc
+	progress Progress
+	resumed  int64
+	start    time.Time
+	last     time.Time
+}
+
+func newProgressWriter(file string, resumed, total int64, fn ProgressFunc) *progressWriter {
+	return &progressWriter{
+		fn: fn,
+		progress: Progress{
+			File:  file,
+			Done:  resumed,
+			Total: total,
+			ETA:   -1,
+		},
+		resumed: resumed,
+		start:   time.Now(),
+	}
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.progress.Done += int64(len(p))
+	if now := time.Now(); now.Sub(w.last) >= progressInterval {
+		w.last = now
+		w.report(now)
+	}
+	return len(p), nil
+}
+
+// finish reports the download as complete.
+func (w *progressWriter) finish() {
+	w.progress.Complete = true
+	w.report(time.Now())
+}
+
+func (w *progressWriter) report(now time.Time) {
+	elapsed := now.Sub(w.start).Seconds()
+	if elapsed > 0 {
+		w.progress.Rate = float64(w.progress.Done-w.resumed) / elapsed
+	}
+	w.progress.ETA = -1
+	if w.progress.Complete {
+		w.progress.ETA = 0
+	} else if w.progress.Total >= 0 && w.progress.Rate > 0 {
+		left := float64(w.progress.Total - w.progress.Done)
+		w.progress.ETA = time.Duration(left/w.progress.Rate) * time.Second
+	}
+	w.fn(w.progress)
+}
@@ -0,0 +1,328 @@
+package coreos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// ReleaseProvider describes a distribution whose releases can be downloaded
+// and booted: where releases are published, what their files are called,
+// which keys sign them and how their kernel is booted.
+type ReleaseProvider interface {
+	// Name selects the provider, as given to --distro.
+	Name() string
+	// Mirrors are the release URL templates to download from, unless
+	// others are given.
+	Mirrors() Mirrors
+	// Kernel and Initrd are the names of a release's PXE kernel and initrd.
+	Kernel() string
+	Initrd() string
+	// Keys are the built in keys trusted to sign releases. There may be
+	// none, in which case keys have to be added to the keyring.
+	Keys() (openpgp.EntityList, error)
+	// ParseRelease parses a release's version.txt.
+	ParseRelease(r io.Reader, channel string) (Release, error)
+	// Cmdline is the kernel command line every VM boots with.
+	Cmdline() string
+	// Ignition returns how a release boots with Ignition, or an error
+	// wrapping ErrNoIgnition if it can't.
+	Ignition(version string) (IgnitionSupport, error)
+}
+
+// Distro is a ReleaseProvider for a distribution laid out like CoreOS:
+// releases are published per channel and board, files are named
+// <prefix>_pxe.vmlinuz and <prefix>_pxe_image.cpio.gz, and version.txt holds
+// <PREFIX>_VERSION_ID and friends. Custom distributions are described by a
+// Distro in a distros file, see LoadDistros.
+type Distro struct {
+	ID          string   `json:"name"`
+	ReleaseURLs []string `json:"release_urls"`
+	// FilePrefix is the prefix of the names of release files, such as
+	// coreos_production.
+	FilePrefix string `json:"file_prefix"`
+	// VersionPrefix is the prefix of the variables in version.txt, such as
+	// COREOS.
+	VersionPrefix string `json:"version_prefix"`
+	// SigningKeys are the armored public keys trusted to sign releases.
+	SigningKeys string `json:"signing_keys,omitempty"`
+	// SigningKeyFile is read into SigningKeys by LoadDistros. A relative
+	// path is relative to the distros file.
+	SigningKeyFile string `json:"signing_key_file,omitempty"`
+	// SigningKeyIDs, if set, are the long IDs SigningKeys must have.
+	SigningKeyIDs []string `json:"signing_key_ids,omitempty"`
+	// KeyFingerprint is the published fingerprint of a signing key that
+	// isn't built in, which keys added for the distribution are pinned to
+	// unless another is given.
+	KeyFingerprint string `json:"key_fingerprint,omitempty"`
+	KernelCmdline  string `json:"cmdline"`
+	// IgnitionSupport is how releases boot with Ignition, oldest first.
+	// Releases older than the first entry, or all of them if there are
+	// none, can't.
+	IgnitionSupport []IgnitionSupport `json:"ignition,omitempty"`
+}
+
+// Image signing key: buildbot@flatcar-linux.org
+const flatcarFingerprint = "F88CFEDEFF29A5B4D9523864E25D9AED0593B34A"
+
+var (
+	// CoreOS releases, signed by the CoreOS buildbot.
+	CoreOS = &Distro{
+		ID:            "coreos",
+		ReleaseURLs:   []string{DefaultReleaseURL},
+		FilePrefix:    "coreos_production",
+		VersionPrefix: "COREOS",
+		SigningKeys:   gpgKey,
+		SigningKeyIDs: []string{gpgLongID},
+		KernelCmdline: "earlyprintk=serial console=ttyS0 coreos.autologin",
+		// each spec version from the first release whose Ignition reads it
+		IgnitionSupport: []IgnitionSupport{
+			coreosIgnition("1010.1.0", "2.0.0"),
+			coreosIgnition("1465.0.0", "2.1.0"),
+			coreosIgnition("1688.0.0", "2.2.0"),
+			coreosIgnition("2107.0.0", "2.3.0"),
+		},
+	}
+	// Flatcar Container Linux releases, signed by the Flatcar buildbot.
+	// Only the published fingerprint of its key is built in.
+	Flatcar = &Distro{
+		ID:             "flatcar",
+		ReleaseURLs:    []string{"https://{channel}.release.flatcar-linux.net/{board}"},
+		FilePrefix:     "flatcar_production",
+		VersionPrefix:  "FLATCAR",
+		KeyFingerprint: flatcarFingerprint,
+		KernelCmdline:  "earlyprintk=serial console=ttyS0 flatcar.autologin",
+		// each spec version from the first release whose Ignition reads
+		// it. Ignition v2, from 3185.0.0 on, reads spec 3 configs and
+		// translates spec 2 ones.
+		IgnitionSupport: []IgnitionSupport{
+			flatcarIgnition("0.0.0", "2.3.0"),
+			flatcarIgnition("3185.0.0", "3.3.0"),
+			flatcarIgnition("3535.0.0", "3.4.0"),
+		},
+	}
+)
+
+// coreosIgnition is how CoreOS releases from minVersion on, which read configs
+// of spec versions up to spec, boot with Ignition.
+func coreosIgnition(minVersion, spec string) IgnitionSupport {
+	return IgnitionSupport{
+		MinVersion:   minVersion,
+		Specs:        ignitionSpecsTo(spec),
+		ConfigArg:    "coreos.config.url",
+		FirstBootArg: "coreos.first_boot",
+	}
+}
+
+// flatcarIgnition is how Flatcar releases from minVersion on, which read
+// configs of spec versions up to spec, boot with Ignition.
+func flatcarIgnition(minVersion, spec string) IgnitionSupport {
+	return IgnitionSupport{
+		MinVersion:   minVersion,
+		Specs:        ignitionSpecsTo(spec),
+		ConfigArg:    "ignition.config.url",
+		FirstBootArg: "flatcar.first_boot",
+	}
+}
+
+// ignitionSpecsTo returns the versions of IgnitionSpecs up to spec. Builtin
+// tables are made from them, so they only name specs the validator knows.
+func ignitionSpecsTo(spec string) []string {
+	var specs []string
+	for _, v := range IgnitionSpecs {
+		specs = append(specs, v)
+		if v == spec {
+			break
+		}
+	}
+	return specs
+}
+
+func (d *Distro) Name() string {
+	return d.ID
+}
+
+func (d *Distro) Mirrors() Mirrors {
+	return Mirrors(d.ReleaseURLs)
+}
+
+func (d *Distro) Kernel() string {
+	return d.FilePrefix + "_pxe.vmlinuz"
+}
+
+func (d *Distro) Initrd() string {
+	return d.FilePrefix + "_pxe_image.cpio.gz"
+}
+
+func (d *Distro) Keys() (openpgp.EntityList, error) {
+	if d.SigningKeys == "" && len(d.SigningKeyIDs) == 0 {
+		return nil, nil
+	}
+	keys, err := readArmoredKeys(strings.NewReader(d.SigningKeys))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s signing keys: %v", d.ID, err)
+	}
+	if len(d.SigningKeyIDs) == 0 {
+		return keys, nil
+	}
+	var ids []string
+	for _, e := range keys {
+		ids = append(ids, fmt.Sprintf("%016X", e.PrimaryKey.KeyId))
+	}
+	want := make([]string, len(d.SigningKeyIDs))
+	for i, id := range d.SigningKeyIDs {
+		want[i] = NormalizeFingerprint(id)
+	}
+	sort.Strings(ids)
+	sort.Strings(want)
+	if strings.Join(ids, ",") != strings.Join(want, ",") {
+		return nil, fmt.Errorf("%s signing keys are %s, expected %s", d.ID, strings.Join(ids, ", "), strings.Join(want, ", "))
+	}
+	return keys, nil
+}
+
+func (d *Distro) ParseRelease(r io.Reader, channel string) (Release, error) {
+	return parseRelease(r, channel, d.VersionPrefix)
+}
+
+func (d *Distro) Cmdline() string {
+	return d.KernelCmdline
+}
+
+func (d *Distro) Ignition(version string) (IgnitionSupport, error) {
+	return ignitionSupport(d.IgnitionSupport, d.ID, version)
+}
+
+// Validate checks that d describes a usable distribution.
+func (d *Distro) Validate() error {
+	switch {
+	case !channelName.MatchString(d.ID):
+		return fmt.Errorf("invalid distribution name %q", d.ID)
+	case len(d.ReleaseURLs) == 0:
+		return fmt.Errorf("distribution %s has no release_urls", d.ID)
+	case d.FilePrefix == "" || strings.ContainsAny(d.FilePrefix, "/."):
+		return fmt.Errorf("distribution %s has an invalid file_prefix %q", d.ID, d.FilePrefix)
+	case d.VersionPrefix == "":
+		return fmt.Errorf("distribution %s has no version_prefix", d.ID)
+	}
+	if err := d.Mirrors().Validate(); err != nil {
+		return fmt.Errorf("distribution %s: %v", d.ID, err)
+	}
+	for _, ign := range d.IgnitionSupport {
+		if _, err := ParseVersion(ign.MinVersion); err != nil {
+			return fmt.Errorf("distribution %s has an invalid ignition min_version: %v", d.ID, err)
+		}
+		if ign.ConfigArg == "" || ign.FirstBootArg == "" || len(ign.Specs) == 0 {
+			return fmt.Errorf("distribution %s needs ignition specs, config_arg and first_boot_arg", d.ID)
+		}
+		for _, spec := range ign.Specs {
+			if !isIgnitionSpec(spec) {
+				return fmt.Errorf("distribution %s has ignition spec %q, which isn't one of %s", d.ID, spec, strings.Join(IgnitionSpecs, ", "))
+			}
+		}
+	}
+	_, err := d.Keys()
+	return err
+}
+
+// DefaultProvider is used when no distribution is chosen.
+var DefaultProvider ReleaseProvider = CoreOS
+
+var (
+	ErrUnknownProvider = errors.New("unknown distribution")
+
+	providers = make(map[string]ReleaseProvider)
+)
+
+func init() {
+	for _, p := range []ReleaseProvider{CoreOS, Flatcar} {
+		if err := RegisterProvider(p); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterProvider makes p available to LookupProvider by its name. It's an
+// error to register two providers with the same name. It's not safe to call
+// concurrently with LookupProvider.
+func RegisterProvider(p ReleaseProvider) error {
+	if _, ok := providers[p.Name()]; ok {
+		return fmt.Errorf("distribution %s is already registered", p.Name())
+	}
+	providers[p.Name()] = p
+	return nil
+}
+
+// LookupProvider returns the provider registered as name, or DefaultProvider
+// if name is empty.
+func LookupProvider(name string) (ReleaseProvider, error) {
+	if name == "" {
+		return DefaultProvider, nil
+	}
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, must be one of %s", ErrUnknownProvider, name, strings.Join(ProviderNames(), ", "))
+	}
+	return p, nil
+}
+
+// ProviderNames returns the names of the registered providers.
+func ProviderNames() []string {
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func orDefaultProvider(p ReleaseProvider) ReleaseProvider {
+	if p == nil {
+		return DefaultProvider
+	}
+	return p
+}
+
+// LoadDistros reads a distros file, a JSON list of Distro, and registers each
+// of them. A missing file isn't an error.
+func LoadDistros(name string) ([]*Distro, error) {
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var distros []*Distro
+	if err := json.Unmarshal(data, &distros); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", name, err)
+	}
+	for _, d := range distros {
+		if d.SigningKeyFile != "" {
+			keyFile := d.SigningKeyFile
+			if !filepath.IsAbs(keyFile) {
+				keyFile = filepath.Join(filepath.Dir(name), keyFile)
+			}
+			keys, err := ioutil.ReadFile(keyFile)
+			if err != nil {
+				return nil, fmt.Errorf("distribution %s: %v", d.ID, err)
+			}
+			d.SigningKeys += "\n" + string(keys)
+		}
+		if err := d.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		if err := RegisterProvider(d); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return distros, nil
+}
@@ -0,0 +1,60 @@
+package coreos
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFlatcarIgnition(t *testing.T) {
+	specs2 := []string{"2.0.0", "2.1.0", "2.2.0", "2.3.0"}
+	specs3 := append(append([]string(nil), specs2...), "3.0.0", "3.1.0", "3.2.0", "3.3.0")
+	tests := []struct {
+		version string
+		specs   []string
+	}{
+		{"1010.1.0", specs2},
+		{"3184.99.0", specs2},
+		{"3185.0.0", specs3},
+		{"3510.2.0", specs3},
+		{"3535.0.0", IgnitionSpecs},
+		{"3760.2.0", IgnitionSpecs},
+		// unknown versions are taken to be new releases
+		{"", IgnitionSpecs},
+	}
+	for _, tt := range tests {
+		ign, err := Flatcar.Ignition(tt.version)
+		if err != nil {
+			t.Errorf("%q: %v", tt.version, err)
+			continue
+		}
+		if !reflect.DeepEqual(ign.Specs, tt.specs) {
+			t.Errorf("%q accepts %v, expected %v", tt.version, ign.Specs, tt.specs)
+		}
+		if ign.ConfigArg != "ignition.config.url" || ign.FirstBootArg != "flatcar.first_boot" {
+			t.Errorf("%q boots with %s and %s", tt.version, ign.ConfigArg, ign.FirstBootArg)
+		}
+	}
+}
+
+// TestIgnitionSpecsInSync checks that the newest releases of each builtin
+// distribution accept every spec the validator knows, and no others.
+func TestIgnitionSpecsInSync(t *testing.T) {
+	latest := map[ReleaseProvider][]string{
+		CoreOS:  {"2.0.0", "2.1.0", "2.2.0", "2.3.0"},
+		Flatcar: IgnitionSpecs,
+	}
+	for p, want := range latest {
+		d := p.(*Distro)
+		for _, ign := range d.IgnitionSupport {
+			for _, spec := range ign.Specs {
+				if !isIgnitionSpec(spec) {
+					t.Errorf("%s %s accepts %s, which isn't in IgnitionSpecs", d.ID, ign.MinVersion, spec)
+				}
+			}
+		}
+		newest := d.IgnitionSupport[len(d.IgnitionSupport)-1]
+		if !reflect.DeepEqual(newest.Specs, want) {
+			t.Errorf("%s %s accepts %v, expected %v", d.ID, newest.MinVersion, newest.Specs, want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package coreos
+
+import (
+	"sort"
+	"time"
+)
+
+// PrunePolicy selects images to delete from a store. Each policy that is set
+// selects images on its own, and an image is pruned if any of them selects
+// it.
+type PrunePolicy struct {
+	// KeepLast keeps the newest KeepLast versions of each distribution,
+	// channel and board. Zero keeps them all.
+	KeepLast int
+	// OlderThan prunes images that haven't been used, or fetched if they
+	// have never been used, for longer than OlderThan. Zero disables it.
+	OlderThan time.Duration
+	// MaxSize prunes the least recently used images until the rest take
+	// up no more than MaxSize bytes. Zero disables it.
+	MaxSize int64
+}
+
+// lastActive is when img was last used, or fetched if it has never been used.
+func (img Image) lastActive() time.Time {
+	if img.LastUsed != nil && img.LastUsed.After(img.Fetched) {
+		return *img.LastUsed
+	}
+	return img.Fetched
+}
+
+// Prunable returns the images policy selects for deletion, as of now. Images
+// a VM is using are never selected, though they count towards MaxSize, and
+// neither are custom images.
+func (s *Store) Prunable(policy PrunePolicy, now time.Time) []Image {
+	s.sort()
+	selected := make(map[int]bool)
+	// pinned images are never selected
+	pinned := make(map[int]bool)
+	for i, img := range s.images {
+		pinned[i] = img.IsCustom() || s.InUse(img)
+	}
+
+	if policy.KeepLast > 0 {
+		// images are sorted oldest version first within each group
+		groups := make(map[[3]string][]int)
+		for i, img := range s.images {
+			key := [3]string{img.Distro, img.Channel, img.Board}
+			groups[key] = append(groups[key], i)
+		}
+		for _, group := range groups {
+			if len(group) <= policy.KeepLast {
+				continue
+			}
+			for _, i := range group[:len(group)-policy.KeepLast] {
+				selected[i] = !pinned[i]
+			}
+		}
+	}
+
+	if policy.OlderThan > 0 {
+		for i, img := range s.images {
+			if now.Sub(img.lastActive()) > policy.OlderThan && !pinned[i] {
+				selected[i] = true
+			}
+		}
+	}
+
+	if policy.MaxSize > 0 {
+		var total int64
+		var rest []int
+		for i, img := range s.images {
+			if !selected[i] {
+				total += img.Size()
+				rest = append(rest, i)
+			}
+		}
+		sort.SliceStable(rest, func(a, b int) bool {
+			return s.images[rest[a]].lastActive().Before(s.images[rest[b]].lastActive())
+		})
+		for _, i := range rest {
+			if total <= policy.MaxSize {
+				break
+			}
+			if pinned[i] {
+				continue
+			}
+			selected[i] = true
+			total -= s.images[i].Size()
+		}
+	}
+
+	var images []Image
+	for i, img := range s.images {
+		if selected[i] {
+			images = append(images, img)
+		}
+	}
+	return images
+}
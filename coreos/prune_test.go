@@ -0,0 +1,146 @@
+package coreos
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// putTestImage writes the kernel and initrd of img to the store's directory,
+// size bytes in all, and adds it to the index. img is a CoreOS release, or a
+// custom image if it has a name.
+func putTestImage(t *testing.T, s *Store, img Image, size int64) Image {
+	img.Distro = CoreOS.Name()
+	img.Board = orDefaultBoard(img.Board)
+	channel, version := img.ref()
+	for _, name := range []string{CoreOS.Kernel(), CoreOS.Initrd()} {
+		f := ImageFile{
+			Name:     name,
+			Path:     imageName(channel, img.Board, version) + "." + name,
+			Size:     size / 2,
+			Verified: VerifiedSignature,
+		}
+		if err := ioutil.WriteFile(s.Path(f.Path), make([]byte, f.Size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		img.Files = append(img.Files, f)
+	}
+	if err := s.Put(img); err != nil {
+		t.Fatalf("unable to add %s: %v", img, err)
+	}
+	return img
+}
+
+func TestPrunable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "core-prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time {
+		return now.Add(-time.Duration(days) * 24 * time.Hour)
+	}
+	used := daysAgo(5)
+	s := &Store{Directory: dir}
+	images := make(map[string]Image)
+	for _, img := range []Image{
+		{Channel: "alpha", Version: "1.0.0", Fetched: daysAgo(30)},
+		{Channel: "alpha", Version: "2.0.0", Fetched: daysAgo(10)},
+		{Channel: "alpha", Version: "3.0.0", Fetched: daysAgo(1)},
+		// fetched long ago, but used recently
+		{Channel: "beta", Version: "1.0.0", Fetched: daysAgo(40), LastUsed: &used},
+		{Channel: "beta", Version: "2.0.0", Fetched: daysAgo(2)},
+		{Name: "dev", Fetched: daysAgo(60)},
+	} {
+		img = putTestImage(t, s, img, 100)
+		channel, version := img.ref()
+		images[channel+"@"+version] = img
+	}
+
+	tests := []struct {
+		name   string
+		policy PrunePolicy
+		inUse  []string
+		want   []string
+	}{
+		{name: "nothing", want: nil},
+		{
+			name:   "keep last",
+			policy: PrunePolicy{KeepLast: 1},
+			want:   []string{"alpha@1.0.0", "alpha@2.0.0", "beta@1.0.0"},
+		},
+		{
+			name:   "keep last in use",
+			policy: PrunePolicy{KeepLast: 1},
+			inUse:  []string{"alpha@1.0.0"},
+			want:   []string{"alpha@2.0.0", "beta@1.0.0"},
+		},
+		{
+			name:   "keep more than there are",
+			policy: PrunePolicy{KeepLast: 3},
+			want:   nil,
+		},
+		{
+			// custom images are older, but never pruned
+			name:   "older than",
+			policy: PrunePolicy{OlderThan: 15 * 24 * time.Hour},
+			want:   []string{"alpha@1.0.0"},
+		},
+		{
+			name:   "older than in use",
+			policy: PrunePolicy{OlderThan: 15 * 24 * time.Hour},
+			inUse:  []string{"alpha@1.0.0"},
+			want:   nil,
+		},
+		{
+			// the custom image is least recently used, and its size
+			// counts though it can't be pruned
+			name:   "max size",
+			policy: PrunePolicy{MaxSize: 400},
+			want:   []string{"alpha@1.0.0", "alpha@2.0.0"},
+		},
+		{
+			name:   "max size in use",
+			policy: PrunePolicy{MaxSize: 400},
+			inUse:  []string{"alpha@1.0.0"},
+			want:   []string{"alpha@2.0.0", "beta@1.0.0"},
+		},
+		{
+			name:   "max size below pinned",
+			policy: PrunePolicy{MaxSize: 50},
+			inUse:  []string{"beta@2.0.0"},
+			want:   []string{"alpha@1.0.0", "alpha@2.0.0", "alpha@3.0.0", "beta@1.0.0"},
+		},
+		{
+			// images kept by KeepLast are pruned by size, least
+			// recently used first
+			name:   "keep last and max size",
+			policy: PrunePolicy{KeepLast: 2, MaxSize: 300},
+			want:   []string{"alpha@1.0.0", "alpha@2.0.0", "beta@1.0.0"},
+		},
+	}
+	for _, tt := range tests {
+		var locks []*FileLock
+		for _, ref := range tt.inUse {
+			lock, err := s.Use(images[ref])
+			if err != nil {
+				t.Fatal(err)
+			}
+			locks = append(locks, lock)
+		}
+		var got []string
+		for _, img := range s.Prunable(tt.policy, now) {
+			got = append(got, img.Channel+"@"+img.Version)
+		}
+		for _, lock := range locks {
+			lock.Unlock()
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: pruned %v, expected %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package coreos
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// CurrentVersion can be used in place of a version number to refer to the
+// current release of a channel.
+const CurrentVersion = "current"
+
+// Channels are the release channels, from least to most stable.
+var Channels = []string{"alpha", "beta", "stable"}
+
+// Release describes a release, as published in its version.txt.
+type Release struct {
+	Channel    string `json:"channel"`
+	Version    string `json:"version"`
+	Build      string `json:"build"`
+	Branch     string `json:"branch"`
+	Patch      string `json:"patch"`
+	BuildID    string `json:"build_id,omitempty"`
+	SDKVersion string `json:"sdk_version,omitempty"`
+}
+
+// ParseRelease parses a CoreOS version.txt, which holds one KEY=value per
+// line.
+func ParseRelease(r io.Reader, channel string) (Release, error) {
+	return parseRelease(r, channel, CoreOS.VersionPrefix)
+}
+
+// parseRelease parses a version.txt whose variables start with prefix.
+func parseRelease(r io.Reader, channel, prefix string) (Release, error) {
+	values := make(map[string]string)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		items := strings.SplitN(strings.TrimSpace(s.Text()), "=", 2)
+		if len(items) != 2 {
+			continue
+		}
+		values[items[0]] = strings.Trim(items[1], `"'`)
+	}
+	if err := s.Err(); err != nil {
+		return Release{}, err
+	}
+	rel := Release{
+		Channel:    channel,
+		Version:    values[prefix+"_VERSION_ID"],
+		Build:      values[prefix+"_BUILD"],
+		Branch:     values[prefix+"_BRANCH"],
+		Patch:      values[prefix+"_PATCH"],
+		BuildID:    values[prefix+"_BUILD_ID"],
+		SDKVersion: values[prefix+"_SDK_VERSION"],
+	}
+	if rel.Version == "" {
+		rel.Version = values[prefix+"_VERSION"]
+	}
+	if rel.Version == "" {
+		return Release{}, fmt.Errorf("Unable to find %s_VERSION_ID in version.txt", prefix)
+	}
+	// the version names files in the image directory
+	if _, err := ParseVersion(rel.Version); err != nil {
+		return Release{}, fmt.Errorf("%s_VERSION_ID in version.txt: %v", prefix, err)
+	}
+	return rel, nil
+}
+
+// GetVersionID returns the current version of channel from the default
+// release server.
+func GetVersionID(channel string) (string, error) {
+	return DefaultClient.GetVersionID(context.Background(), channel)
+}
+
+// GetVersionID returns the current version of channel.
+func (c *Client) GetVersionID(ctx context.Context, channel string) (string, error) {
+	rel, err := c.GetRelease(ctx, channel, CurrentVersion)
+	if err != nil {
+		return "", err
+	}
+	return rel.Version, nil
+}
+
+// GetRelease describes a version of channel, which may be CurrentVersion.
+func (c *Client) GetRelease(ctx context.Context, channel, version string) (Release, error) {
+	data, err := c.readFile(ctx, channel, c.Board, path.Join(version, "version.txt"))
+	if err != nil {
+		notFound := ErrVersionNotFound
+		if version == CurrentVersion {
+			notFound = ErrChannelNotFound
+		}
+		return Release{}, releaseError(err, notFound, channel, version)
+	}
+	return c.provider().ParseRelease(bytes.NewReader(data), channel)
+}
+
+// versionLink matches links to release directories in a directory listing.
+var versionLink = regexp.MustCompile(`href="(?:[^"]*/)?([0-9]+\.[0-9]+\.[0-9]+[^"/]*)/?"`)
+
+// ListVersions returns the versions released in channel, oldest first. It
+// relies on the release server listing the channel's directory.
+func (c *Client) ListVersions(ctx context.Context, channel string) ([]string, error) {
+	data, err := c.readFile(ctx, channel, c.Board, "")
+	if err != nil {
+		return nil, releaseError(err, ErrChannelNotFound, channel, "")
+	}
+	seen := make(map[string]bool)
+	var versions []string
+	for _, m := range versionLink.FindAllSubmatch(data, -1) {
+		version := string(m[1])
+		if _, err := ParseVersion(version); err != nil || seen[version] {
+			continue
+		}
+		seen[version] = true
+		versions = append(versions, version)
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no versions listed for channel %s", channel)
+	}
+	SortVersions(versions)
+	return versions, nil
+}
+
+// ResolveVersion returns the version of spec.Channel that spec selects, out
+// of those released. If the release server doesn't list the channel's
+// releases, only the current release or an exact version can be resolved.
+func (c *Client) ResolveVersion(ctx context.Context, spec VersionSpec) (string, error) {
+	if spec.IsLatest() && spec.Back == 0 {
+		return c.GetVersionID(ctx, spec.Channel)
+	}
+	versions, err := c.ListVersions(ctx, spec.Channel)
+	if err != nil && spec.Version != "" && spec.Back == 0 {
+		plog.Debugf("Unable to list releases in channel %s, not checking version %s. err: %v", spec.Channel, spec.Version, err)
+		return spec.Version, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	version, err := spec.Resolve(versions)
+	if errors.Is(err, ErrNoMatchingVersion) {
+		newest := versions
+		if len(newest) > 5 {
+			newest = newest[len(newest)-5:]
+		}
+		return "", &ReleaseError{
+			Channel: spec.Channel,
+			Version: strings.TrimPrefix(spec.String(), spec.Channel+"@"),
+			Err:     ErrVersionNotFound,
+			Cause:   fmt.Errorf("the newest releases are %v", newest),
+		}
+	}
+	return version, err
+}
@@ -0,0 +1,201 @@
+package coreos
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LatestVersion can be used in a version specifier to select the newest
+// release. Like CurrentVersion, which means the same thing there.
+const LatestVersion = "latest"
+
+// ErrNoMatchingVersion is returned when no version matches a specifier.
+var ErrNoMatchingVersion = errors.New("no matching version")
+
+var (
+	channelName = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+	backSuffix  = regexp.MustCompile(`~([0-9]+)$`)
+)
+
+// VersionSpec selects a version of a channel. Its string form is
+//
+//	[channel][@version][~N]
+//
+// where version is an exact version such as 1010.1.0, latest or current for
+// the newest release, a pattern such as 1010.x or 1010.1.*, or comma
+// separated comparisons such as >=1000.0.0,<1100. A spec without a channel may
+// leave out the @, as in >=1000.0.0. ~N selects the Nth release before the
+// newest that matches, so stable~1 is the previous stable release.
+type VersionSpec struct {
+	// Channel is the release channel, empty for the default.
+	Channel string
+	// Version is set when the spec names one exact version.
+	Version string
+	// Back is how many matching releases to skip, newest first.
+	Back int
+
+	constraints []versionConstraint
+	query       string
+}
+
+// versionConstraint is one comparison, or a pattern if op is "x".
+type versionConstraint struct {
+	op      string
+	version Version
+	// fixed is how many of major, minor and patch a pattern fixes
+	fixed int
+}
+
+// ParseVersionSpec parses a version specifier.
+func ParseVersionSpec(s string) (VersionSpec, error) {
+	var spec VersionSpec
+	rest := strings.TrimSpace(s)
+	if m := backSuffix.FindStringSubmatch(rest); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return VersionSpec{}, fmt.Errorf("invalid version specifier %q: %v", s, err)
+		}
+		spec.Back = n
+		rest = strings.TrimSuffix(rest, m[0])
+	}
+	switch i := strings.Index(rest, "@"); {
+	case i >= 0:
+		spec.Channel, rest = rest[:i], rest[i+1:]
+		if !channelName.MatchString(spec.Channel) {
+			return VersionSpec{}, fmt.Errorf("invalid version specifier %q: invalid channel %q", s, spec.Channel)
+		}
+	case channelName.MatchString(rest) && rest != LatestVersion && rest != CurrentVersion:
+		spec.Channel, rest = rest, ""
+	}
+	if err := spec.parseQuery(rest); err != nil {
+		return VersionSpec{}, fmt.Errorf("invalid version specifier %q: %v", s, err)
+	}
+	return spec, nil
+}
+
+func (s *VersionSpec) parseQuery(query string) error {
+	if query == "" || query == LatestVersion || query == CurrentVersion {
+		return nil
+	}
+	s.query = query
+	for _, part := range strings.Split(query, ",") {
+		c, err := parseConstraint(strings.TrimSpace(part))
+		if err != nil {
+			return err
+		}
+		s.constraints = append(s.constraints, c)
+	}
+	if len(s.constraints) == 1 && s.constraints[0].op == "=" {
+		s.Version = strings.TrimPrefix(query, "=")
+	}
+	return nil
+}
+
+func parseConstraint(s string) (versionConstraint, error) {
+	op := "="
+	for _, prefix := range []string{">=", "<=", "!=", ">", "<", "="} {
+		if strings.HasPrefix(s, prefix) {
+			op, s = prefix, strings.TrimSpace(s[len(prefix):])
+			break
+		}
+	}
+	parts := strings.Split(s, ".")
+	for i, part := range parts {
+		if part != "x" && part != "X" && part != "*" {
+			continue
+		}
+		if op != "=" || i != len(parts)-1 || i == 0 || i > 2 {
+			return versionConstraint{}, fmt.Errorf("invalid version pattern %q", s)
+		}
+		v, err := ParseVersion(strings.Join(parts[:i], "."))
+		if err != nil {
+			return versionConstraint{}, err
+		}
+		return versionConstraint{op: "x", version: v, fixed: i}, nil
+	}
+	v, err := ParseVersion(s)
+	if err != nil {
+		return versionConstraint{}, err
+	}
+	return versionConstraint{op: op, version: v}, nil
+}
+
+func (c versionConstraint) matches(v Version) bool {
+	if c.op == "x" {
+		return v.Major == c.version.Major &&
+			(c.fixed < 2 || v.Minor == c.version.Minor)
+	}
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	}
+	return cmp == 0
+}
+
+// IsLatest reports whether s selects the newest release, ignoring Back.
+func (s VersionSpec) IsLatest() bool {
+	return len(s.constraints) == 0
+}
+
+// Query returns the version part of s, without the channel and ~N.
+func (s VersionSpec) Query() string {
+	if s.IsLatest() {
+		return LatestVersion
+	}
+	return s.query
+}
+
+func (s VersionSpec) String() string {
+	str := s.Query()
+	if s.Channel != "" {
+		str = s.Channel + "@" + str
+	}
+	if s.Back > 0 {
+		str = fmt.Sprintf("%s~%d", str, s.Back)
+	}
+	return str
+}
+
+// Matches reports whether version satisfies every constraint of s.
+func (s VersionSpec) Matches(version string) bool {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return false
+	}
+	for _, c := range s.constraints {
+		if !c.matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Resolve picks the version s selects out of versions, in semantic order.
+func (s VersionSpec) Resolve(versions []string) (string, error) {
+	var matching []string
+	for _, v := range versions {
+		if s.Matches(v) {
+			matching = append(matching, v)
+		}
+	}
+	SortVersions(matching)
+	if s.Back < len(matching) {
+		return matching[len(matching)-1-s.Back], nil
+	}
+	if len(matching) == 0 {
+		return "", fmt.Errorf("%w for %s", ErrNoMatchingVersion, s)
+	}
+	return "", fmt.Errorf("%w for %s: only %d releases match", ErrNoMatchingVersion, s, len(matching))
+}
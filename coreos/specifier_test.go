@@ -0,0 +1,160 @@
+package coreos
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseVersionSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		channel string
+		version string
+		back    int
+		latest  bool
+		err     bool
+	}{
+		{spec: "", latest: true},
+		{spec: "stable", channel: "stable", latest: true},
+		{spec: "latest", latest: true},
+		{spec: "current", latest: true},
+		{spec: "beta@latest", channel: "beta", latest: true},
+		{spec: "beta@current", channel: "beta", latest: true},
+		{spec: "1010.1.0", version: "1010.1.0"},
+		{spec: "1010.1.0-rc.1+build-2", version: "1010.1.0-rc.1+build-2"},
+		{spec: "1010.1.0+", version: "1010.1.0+"},
+		{spec: "=1010.1.0", version: "1010.1.0"},
+		{spec: "alpha@1010.1.0", channel: "alpha", version: "1010.1.0"},
+		{spec: "alpha@1010.x", channel: "alpha"},
+		{spec: "1010.1.*", channel: ""},
+		{spec: "1010.X"},
+		{spec: ">=1000.0.0"},
+		{spec: ">=1000.0.0,<1100"},
+		{spec: "stable@>=1000.0.0, <1100, !=1010.1.0", channel: "stable"},
+		{spec: "stable~1", channel: "stable", back: 1, latest: true},
+		{spec: "stable@1010.x~2", channel: "stable", back: 2},
+		{spec: "~3", back: 3, latest: true},
+		{spec: "alpha@", channel: "alpha", latest: true},
+
+		{spec: "Stable@1010.1.0", err: true},
+		{spec: "@1010.1.0", err: true},
+		{spec: "1010.1.0.0", err: true},
+		{spec: "1.2.3.x", err: true},
+		{spec: "1.2.3.4.x", err: true},
+		{spec: "alpha@x", err: true},
+		{spec: "*", err: true},
+		{spec: "1010.x.0", err: true},
+		{spec: ">=1010.x", err: true},
+		{spec: "^1010.1.0", err: true},
+		{spec: "1010.1.0~", err: true},
+		{spec: "~1010.1", err: true},
+		{spec: ">=1000.0.0,", err: true},
+		{spec: "1010.-1.0", err: true},
+		{spec: "=>1010", err: true},
+		{spec: "1.0.0-/../../../tmp/x", err: true},
+		{spec: "alpha@1.0.0+../x", err: true},
+		{spec: "1.0.0-a b", err: true},
+		{spec: "1.0.0-a\\b", err: true},
+	}
+	for _, tt := range tests {
+		s, err := ParseVersionSpec(tt.spec)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseVersionSpec(%q) = %v, expected an error", tt.spec, s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseVersionSpec(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		if s.Channel != tt.channel || s.Version != tt.version || s.Back != tt.back || s.IsLatest() != tt.latest {
+			t.Errorf("ParseVersionSpec(%q) = channel %q, version %q, back %d, latest %t, expected %q, %q, %d, %t",
+				tt.spec, s.Channel, s.Version, s.Back, s.IsLatest(), tt.channel, tt.version, tt.back, tt.latest)
+		}
+	}
+}
+
+func TestVersionSpecResolve(t *testing.T) {
+	// out of order, and so that string ordering would pick the wrong ones
+	versions := []string{"1010.5.0", "899.1.0", "1010.10.0", "1122.0.0", "1010.1.0", "1000.0.0", "1122.2.0"}
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"latest", "1122.2.0"},
+		{"stable", "1122.2.0"},
+		{"~1", "1122.0.0"},
+		{"~6", "899.1.0"},
+		{"~7", ""},
+		{"1010.5.0", "1010.5.0"},
+		{"1010.6.0", ""},
+		{"1010.x", "1010.10.0"},
+		{"1010.x~2", "1010.1.0"},
+		{"1010.1.x", "1010.1.0"},
+		{"1122.*", "1122.2.0"},
+		{"900.x", ""},
+		{">=1000.0.0,<1100", "1010.10.0"},
+		{">1000,<=1010.5", "1010.5.0"},
+		{"<1000", "899.1.0"},
+		{"<=1000", "1000.0.0"},
+		{"!=1122.2.0", "1122.0.0"},
+		{">=899,<1010~1", "899.1.0"},
+		{">=1000.0.0,<1010~1", ""},
+		{">2000", ""},
+	}
+	for _, tt := range tests {
+		s, err := ParseVersionSpec(tt.spec)
+		if err != nil {
+			t.Errorf("ParseVersionSpec(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		got, err := s.Resolve(versions)
+		if tt.want == "" {
+			if err == nil || !strings.Contains(err.Error(), ErrNoMatchingVersion.Error()) {
+				t.Errorf("%q resolved to %q, %v, expected %v", tt.spec, got, err, ErrNoMatchingVersion)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%q resolved to %q, %v, expected %q", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    Version
+		err     bool
+	}{
+		{version: "1010.5.0", want: Version{Major: 1010, Minor: 5}},
+		{version: "1010", want: Version{Major: 1010}},
+		{version: "1010.5.0+build.3", want: Version{Major: 1010, Minor: 5, Extra: "+build.3"}},
+		{version: "3.0.0-rc-1", want: Version{Major: 3, Extra: "-rc-1"}},
+		{version: "1.0.0-..", want: Version{Major: 1, Extra: "-.."}},
+
+		{version: "", err: true},
+		{version: "1.0.0.0", err: true},
+		{version: "1.a.0", err: true},
+		{version: "1.0.0-/../../../tmp/x", err: true},
+		{version: "1.0.0-../x", err: true},
+		{version: "1.0.0+a/b", err: true},
+		{version: "1.0.0-a\\b", err: true},
+		{version: "1.0.0-a b", err: true},
+		{version: "1.0.0-a\x00", err: true},
+		{version: "1.0.0-a_b", err: true},
+	}
+	for _, tt := range tests {
+		v, err := ParseVersion(tt.version)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseVersion(%q) = %v, expected an error", tt.version, v)
+			}
+			continue
+		}
+		if err != nil || v != tt.want {
+			t.Errorf("ParseVersion(%q) = %v, %v, expected %v", tt.version, v, err, tt.want)
+		}
+	}
+}
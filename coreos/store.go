@@ -0,0 +1,610 @@
+package coreos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// indexFile is the name of the store's index, inside the image directory.
+const indexFile = "index.json"
+
+// indexVersion is bumped whenever the index format changes incompatibly.
+const indexVersion = 1
+
+// Verification is how a stored file was checked when it was added.
+type Verification string
+
+const (
+	// VerifiedSignature files had a valid signature by a trusted key.
+	VerifiedSignature Verification = "signature"
+	// VerifiedDigests files matched the digests they were published with.
+	VerifiedDigests Verification = "digests"
+	// Unverified files were imported without a signature or digests.
+	Unverified Verification = "none"
+)
+
+// Sources of images in the store.
+const (
+	SourceDownload = "download"
+	SourceImport   = "import"
+	// SourceMigrated images were found in the image directory when the
+	// index was created.
+	SourceMigrated = "migrated"
+	// SourceCustom images were built locally and added with AddCustom.
+	SourceCustom = "custom"
+)
+
+// ImageFile is one file of a stored image.
+type ImageFile struct {
+	// Name of the file in the release, such as
+	// coreos_production_pxe.vmlinuz.
+	Name string `json:"name"`
+	// Path of the file, relative to the image directory.
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Digests recorded when the file was added.
+	Digests Digests `json:"digests"`
+	// Signature is the path of the detached signature, if there is one.
+	Signature string       `json:"signature,omitempty"`
+	Verified  Verification `json:"verified"`
+}
+
+// Image is a release in the image store, or a custom image.
+type Image struct {
+	Distro  string `json:"distro"`
+	Channel string `json:"channel"`
+	Board   string `json:"board"`
+	Version string `json:"version"`
+	// Name of a custom image, which has no channel or version.
+	Name     string      `json:"name,omitempty"`
+	Files    []ImageFile `json:"files"`
+	Source   string      `json:"source"`
+	Fetched  time.Time   `json:"fetched"`
+	LastUsed *time.Time  `json:"last_used,omitempty"`
+}
+
+func (img Image) String() string {
+	channel, version := img.ref()
+	return fmt.Sprintf("%s %s %s (%s)", img.Distro, channel, version, img.Board)
+}
+
+func (img Image) is(distro, channel, board, version string) bool {
+	return img.Distro == distro && img.Channel == channel && img.Board == orDefaultBoard(board) && img.Version == version
+}
+
+// same reports whether img and other are the same image.
+func (img Image) same(other Image) bool {
+	return img.is(other.Distro, other.Channel, other.Board, other.Version) && img.Name == other.Name
+}
+
+// IsCustom reports whether img is a custom image.
+func (img Image) IsCustom() bool {
+	return img.Name != ""
+}
+
+// ref returns the channel and version that name img's files and locks, which
+// for a custom image are CustomChannel and its name.
+func (img Image) ref() (channel, version string) {
+	if img.IsCustom() {
+		return CustomChannel, img.Name
+	}
+	return img.Channel, img.Version
+}
+
+// File returns the file of img with the given release file name.
+func (img Image) File(name string) (ImageFile, bool) {
+	for _, f := range img.Files {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return ImageFile{}, false
+}
+
+// bootable reports whether img has the kernel and initrd of p.
+func (img Image) bootable(p ReleaseProvider) bool {
+	_, kernel := img.File(p.Kernel())
+	_, initrd := img.File(p.Initrd())
+	return kernel && initrd
+}
+
+// Size is the total size of img's files.
+func (img Image) Size() int64 {
+	var size int64
+	for _, f := range img.Files {
+		size += f.Size
+	}
+	return size
+}
+
+// Verified returns the weakest verification of img's files.
+func (img Image) Verified() Verification {
+	v := VerifiedSignature
+	for _, f := range img.Files {
+		switch f.Verified {
+		case VerifiedDigests:
+			if v == VerifiedSignature {
+				v = VerifiedDigests
+			}
+		case VerifiedSignature:
+		default:
+			return Unverified
+		}
+	}
+	return v
+}
+
+// index is the format of the index file.
+type index struct {
+	Version int     `json:"version"`
+	Images  []Image `json:"images"`
+}
+
+// Store keeps track of the images in an image directory with an index of
+// their files, digests and how they were verified. Opening a store without an
+// index creates one, migrating any images already in the directory.
+type Store struct {
+	Directory string
+	images    []Image
+}
+
+// OpenStore reads the index of the image store in directory. Images whose
+// files have been deleted are dropped from it.
+func OpenStore(directory string) (*Store, error) {
+	s := &Store{Directory: directory}
+	lock, err := lockIndex(directory, false)
+	if err != nil {
+		return nil, err
+	}
+	dirty, err := s.load(false)
+	lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if dirty {
+		// migrating and dropping images needs an exclusive lock, under
+		// which the index is read again
+		if err := s.update(func() error { return nil }); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// load reads the index, reporting whether it needs to be saved because it
+// doesn't exist yet or lists files that are missing. Those are only fixed,
+// by migrating or dropping images, if fix is set.
+func (s *Store) load(fix bool) (bool, error) {
+	s.images = nil
+	data, err := ioutil.ReadFile(s.indexPath())
+	if os.IsNotExist(err) {
+		if !fix {
+			return true, nil
+		}
+		if err := s.migrate(); err != nil {
+			return false, fmt.Errorf("unable to index images in %s: %v", s.Directory, err)
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var idx index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return false, fmt.Errorf("unable to read image index %s: %v", s.indexPath(), err)
+	}
+	if idx.Version != indexVersion {
+		return false, fmt.Errorf("image index %s has unsupported version %d", s.indexPath(), idx.Version)
+	}
+	dirty := false
+	for _, img := range idx.Images {
+		if missing := s.missingFile(img); missing != "" {
+			dirty = true
+			if fix {
+				plog.Warningf("Removing %s from the image index, %s is missing", img, missing)
+				continue
+			}
+		}
+		s.images = append(s.images, img)
+	}
+	return dirty, nil
+}
+
+// update reads the index again under an exclusive lock, applies fn to it and
+// saves it, so that changes made by other processes aren't lost.
+func (s *Store) update(fn func() error) error {
+	lock, err := lockIndex(s.Directory, true)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if _, err := s.load(true); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *Store) indexPath() string {
+	return path.Join(s.Directory, indexFile)
+}
+
+// Path returns the path of a file of an image, which may be relative to the
+// image directory.
+func (s *Store) Path(name string) string {
+	if name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return path.Join(s.Directory, name)
+}
+
+func (s *Store) missingFile(img Image) string {
+	for _, f := range img.Files {
+		if _, err := os.Stat(s.Path(f.Path)); err != nil {
+			return f.Path
+		}
+	}
+	return ""
+}
+
+// save writes the index, replacing the old one atomically.
+func (s *Store) save() error {
+	s.sort()
+	data, err := json.MarshalIndent(index{Version: indexVersion, Images: s.images}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Directory, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(s.Directory, "."+indexFile)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(data, '\n'))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.indexPath())
+}
+
+// sort orders images by distro, channel and board, oldest version first.
+func (s *Store) sort() {
+	sort.SliceStable(s.images, func(i, j int) bool {
+		a, b := s.images[i], s.images[j]
+		switch {
+		case a.Distro != b.Distro:
+			return a.Distro < b.Distro
+		case a.Channel != b.Channel:
+			return a.Channel < b.Channel
+		case a.Board != b.Board:
+			return a.Board < b.Board
+		case a.Name != b.Name:
+			return a.Name < b.Name
+		}
+		return CompareVersions(a.Version, b.Version) < 0
+	})
+}
+
+// Images returns every image in the store.
+func (s *Store) Images() []Image {
+	return append([]Image(nil), s.images...)
+}
+
+// Get returns the image of a version of p, channel and board.
+func (s *Store) Get(p ReleaseProvider, channel, board, version string) (Image, bool) {
+	p = orDefaultProvider(p)
+	for _, img := range s.images {
+		if img.is(p.Name(), channel, board, version) {
+			return img, true
+		}
+	}
+	return Image{}, false
+}
+
+// Versions returns the versions of p, channel and board that have a kernel
+// and initrd, oldest first.
+func (s *Store) Versions(p ReleaseProvider, channel, board string) []string {
+	p = orDefaultProvider(p)
+	var versions []string
+	for _, img := range s.images {
+		if img.Distro == p.Name() && img.Channel == channel && img.Board == orDefaultBoard(board) && img.bootable(p) {
+			versions = append(versions, img.Version)
+		}
+	}
+	SortVersions(versions)
+	return versions
+}
+
+// Find returns the image spec selects out of those of p, channel and board,
+// in semantic order. spec.Channel is ignored.
+func (s *Store) Find(p ReleaseProvider, channel, board string, spec VersionSpec) (Image, error) {
+	versions := s.Versions(p, channel, board)
+	if len(versions) == 0 {
+		return Image{}, ErrNoLocalImages
+	}
+	version, err := spec.Resolve(versions)
+	if err != nil {
+		return Image{}, err
+	}
+	img, _ := s.Get(p, channel, board, version)
+	return img, nil
+}
+
+// Put adds img to the index, replacing any image of the same version.
+func (s *Store) Put(img Image) error {
+	img.Board = orDefaultBoard(img.Board)
+	return s.update(func() error {
+		replaced := false
+		for i, old := range s.images {
+			if old.same(img) {
+				s.images[i] = img
+				replaced = true
+			}
+		}
+		if !replaced {
+			s.images = append(s.images, img)
+		}
+		return nil
+	})
+}
+
+// Delete removes img from the index, leaving its files alone.
+func (s *Store) Delete(img Image) error {
+	return s.update(func() error {
+		images := s.images[:0]
+		for _, old := range s.images {
+			if !old.same(img) {
+				images = append(images, old)
+			}
+		}
+		s.images = images
+		return nil
+	})
+}
+
+// Touch records that img was just used.
+func (s *Store) Touch(img Image) error {
+	return s.update(func() error {
+		for i, old := range s.images {
+			if old.same(img) {
+				now := time.Now().UTC()
+				s.images[i].LastUsed = &now
+			}
+		}
+		return nil
+	})
+}
+
+// Use locks img for a VM booting it, until the returned lock is released,
+// so that it isn't removed or replaced in the meantime. It's an error if
+// img's files were removed before the lock was taken.
+func (s *Store) Use(img Image) (*FileLock, error) {
+	p, err := LookupProvider(img.Distro)
+	if err != nil {
+		return nil, err
+	}
+	channel, version := img.ref()
+	lock, err := lockImage(s.Directory, p, channel, img.Board, version, false)
+	if err != nil {
+		return nil, err
+	}
+	if missing := s.missingFile(img); missing != "" {
+		lock.Unlock()
+		return nil, fmt.Errorf("%s was removed", missing)
+	}
+	return lock, nil
+}
+
+// InUse reports whether a VM is using img.
+func (s *Store) InUse(img Image) bool {
+	lock, err := s.lockExclusive(img)
+	if err != nil {
+		return errors.Is(err, ErrImageInUse)
+	}
+	lock.Unlock()
+	return false
+}
+
+// lockExclusive locks img to remove or replace its files, failing with
+// ErrImageInUse if a VM is using it.
+func (s *Store) lockExclusive(img Image) (*FileLock, error) {
+	p, err := LookupProvider(img.Distro)
+	if err != nil {
+		return nil, err
+	}
+	channel, version := img.ref()
+	return lockImage(s.Directory, p, channel, img.Board, version, true)
+}
+
+// record adds the release files of a version to the index, from the files
+// in the image directory named like a downloader names them.
+func (s *Store) record(p ReleaseProvider, channel, board, version, source string, files map[string]Verification) (Image, error) {
+	p = orDefaultProvider(p)
+	img := Image{
+		Distro:  p.Name(),
+		Channel: channel,
+		Board:   orDefaultBoard(board),
+		Version: version,
+		Source:  source,
+		Fetched: time.Now().UTC(),
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f, err := s.imageFile(imageFileName(p, channel, board, version)+"."+name, name, files[name])
+		if err != nil {
+			return Image{}, err
+		}
+		img.Files = append(img.Files, f)
+	}
+	return img, s.Put(img)
+}
+
+// imageFile describes the file at rel, a path relative to the image
+// directory, using its recorded digests if it has any.
+func (s *Store) imageFile(rel, name string, verified Verification) (ImageFile, error) {
+	loc := s.Path(rel)
+	fi, err := os.Stat(loc)
+	if err != nil {
+		return ImageFile{}, err
+	}
+	digests, err := ReadDigests(loc)
+	if err != nil {
+		if digests, err = DigestFile(loc); err != nil {
+			return ImageFile{}, err
+		}
+	}
+	f := ImageFile{
+		Name:     name,
+		Path:     rel,
+		Size:     fi.Size(),
+		Digests:  digests,
+		Verified: verified,
+	}
+	if _, err := os.Stat(loc + ".sig"); err == nil {
+		f.Signature = rel + ".sig"
+	}
+	return f, nil
+}
+
+// migrate creates the index from the images already in the image directory,
+// named [distro.]channel.[board.]version.file, which predate it. Nothing is
+// known about how their files were checked, so they're recorded as
+// Unverified, with their signatures for core images verify to check.
+func (s *Store) migrate() error {
+	entries, err := ioutil.ReadDir(s.Directory)
+	if os.IsNotExist(err) {
+		// nothing to migrate, the index is created with the first image
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, fi := range entries {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		p, prefix, channel, board, version, ok := parseKernelName(fi.Name())
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(s.Path(prefix + "." + p.Initrd())); err != nil {
+			continue
+		}
+		img := Image{
+			Distro:  p.Name(),
+			Channel: channel,
+			Board:   board,
+			Version: version,
+			Source:  SourceMigrated,
+			Fetched: fi.ModTime().UTC(),
+		}
+		for _, file := range []string{p.Kernel(), p.Initrd()} {
+			f, err := s.imageFile(prefix+"."+file, file, Unverified)
+			if err != nil {
+				return err
+			}
+			img.Files = append(img.Files, f)
+		}
+		plog.Infof("Adding %s to the image index", img)
+		s.images = append(s.images, img)
+	}
+	return nil
+}
+
+// parseKernelName returns the provider a kernel in the image directory is a
+// release of, and the prefix of the names of the release's files. Names
+// without a distribution, from before the files of distributions other than
+// DefaultProvider were prefixed with it, belong to the first provider whose
+// kernels are named like them, DefaultProvider first, so that distributions
+// naming their files alike don't both claim them.
+func parseKernelName(name string) (p ReleaseProvider, prefix, channel, board, version string, ok bool) {
+	providers := []ReleaseProvider{DefaultProvider}
+	for _, n := range ProviderNames() {
+		if p, _ := LookupProvider(n); n != DefaultProvider.Name() {
+			providers = append(providers, p)
+		}
+	}
+	for _, p = range providers {
+		prefix = strings.TrimSuffix(name, "."+p.Kernel())
+		if prefix == name {
+			continue
+		}
+		if rest := strings.TrimPrefix(prefix, p.Name()+"."); rest != prefix && p.Name() != DefaultProvider.Name() {
+			if channel, board, version, ok = parseImageName(rest); ok {
+				return
+			}
+		}
+		if channel, board, version, ok = parseImageName(prefix); ok {
+			return
+		}
+	}
+	return nil, "", "", "", "", false
+}
+
+// parseImageName splits the channel.[board.]version prefix of the names of
+// files in the image directory.
+func parseImageName(name string) (channel, board, version string, ok bool) {
+	i := strings.Index(name, ".")
+	if i < 0 {
+		return "", "", "", false
+	}
+	channel, version, board = name[:i], name[i+1:], DefaultBoard
+	for _, b := range Boards {
+		if strings.HasPrefix(version, b+".") {
+			board, version = b, strings.TrimPrefix(version, b+".")
+		}
+	}
+	if _, err := ParseVersion(version); err != nil || !channelName.MatchString(channel) {
+		return "", "", "", false
+	}
+	return channel, board, version, true
+}
+
+// Remove deletes the files of img, along with their signatures and recorded
+// digests, and removes it from the index. It fails with ErrImageInUse if a
+// VM is using img.
+func (s *Store) Remove(img Image) error {
+	lock, err := s.lockExclusive(img)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if err := s.Delete(img); err != nil {
+		return err
+	}
+	var errs Errors
+	for _, f := range img.Files {
+		loc := s.Path(f.Path)
+		for _, name := range []string{loc, s.Path(f.Signature), loc + digestsSuffix} {
+			if name == "" {
+				continue
+			}
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package coreos
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func imageStrings(images []Image) []string {
+	var s []string
+	for _, img := range images {
+		s = append(s, img.String())
+	}
+	return s
+}
+
+func TestStorePutDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "core-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Store{Directory: dir}
+	fetched := time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+	alpha := putTestImage(t, s, Image{Channel: "alpha", Version: "1010.1.0", Fetched: fetched}, 100)
+	beta := putTestImage(t, s, Image{Channel: "beta", Version: "1000.0.0", Fetched: fetched}, 100)
+	arm := putTestImage(t, s, Image{Channel: "alpha", Board: "arm64-usr", Version: "1010.1.0", Fetched: fetched}, 100)
+
+	tests := []struct {
+		name string
+		fn   func() error
+		want []string
+	}{
+		{
+			name: "put",
+			fn:   func() error { return nil },
+			want: []string{"coreos alpha 1010.1.0 (amd64-usr)", "coreos alpha 1010.1.0 (arm64-usr)", "coreos beta 1000.0.0 (amd64-usr)"},
+		},
+		{
+			// the board defaults, and the same version is replaced
+			name: "replace",
+			fn: func() error {
+				img := alpha
+				img.Board = ""
+				img.Source = SourceImport
+				return s.Put(img)
+			},
+			want: []string{"coreos alpha 1010.1.0 (amd64-usr)", "coreos alpha 1010.1.0 (arm64-usr)", "coreos beta 1000.0.0 (amd64-usr)"},
+		},
+		{
+			name: "delete",
+			fn:   func() error { return s.Delete(arm) },
+			want: []string{"coreos alpha 1010.1.0 (amd64-usr)", "coreos beta 1000.0.0 (amd64-usr)"},
+		},
+		{
+			name: "delete missing",
+			fn:   func() error { return s.Delete(arm) },
+			want: []string{"coreos alpha 1010.1.0 (amd64-usr)", "coreos beta 1000.0.0 (amd64-usr)"},
+		},
+		{
+			// images whose files are gone are dropped when the index
+			// is opened
+			name: "files removed",
+			fn:   func() error { return os.Remove(s.Path(beta.Files[0].Path)) },
+			want: []string{"coreos alpha 1010.1.0 (amd64-usr)"},
+		},
+	}
+	for _, tt := range tests {
+		if err := tt.fn(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		// read back what was saved
+		opened, err := OpenStore(dir)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := imageStrings(opened.Images()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: store has %v, expected %v", tt.name, got, tt.want)
+		}
+	}
+
+	opened, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, ok := opened.Get(nil, "alpha", "", "1010.1.0")
+	if !ok || img.Source != SourceImport {
+		t.Errorf("replaced image is %+v", img)
+	}
+	// deleting an image leaves its files
+	for _, f := range arm.Files {
+		if _, err := os.Stat(s.Path(f.Path)); err != nil {
+			t.Errorf("deleted image's file: %v", err)
+		}
+	}
+}
+
+func TestStoreMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "core-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a distribution naming its files like Flatcar does
+	twin := &Distro{ID: "twin", ReleaseURLs: []string{"http://twin/{channel}"}, FilePrefix: "flatcar_production", VersionPrefix: "FLATCAR"}
+	providers[twin.ID] = twin
+	defer delete(providers, twin.ID)
+
+	releases := map[string]ReleaseProvider{
+		"alpha.1010.1.0":           CoreOS,
+		"beta.arm64-usr.1100.0.0":  CoreOS,
+		"stable.3000.0.0":          Flatcar,
+		"flatcar.beta.3200.0.0":    Flatcar,
+		"twin.stable.3100.0.0":     twin,
+		"alpha.1.0.0-rc.1+build.2": CoreOS,
+	}
+	for prefix, p := range releases {
+		for _, file := range []string{p.Kernel(), p.Initrd()} {
+			if err := ioutil.WriteFile(path.Join(dir, prefix+"."+file), []byte(file), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, name := range []string{
+		// no initrd
+		"alpha.899.0.0." + CoreOS.Kernel(),
+		"notes.txt",
+		"alpha." + CoreOS.Kernel(),
+		"Alpha.1010.1.0." + CoreOS.Kernel(),
+		"alpha.1010.1.0." + CoreOS.Kernel() + ".sig",
+	} {
+		if err := ioutil.WriteFile(path.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(path.Join(dir, "beta.1000.0.0."+CoreOS.Kernel()), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"coreos alpha 1.0.0-rc.1+build.2 (amd64-usr)",
+		"coreos alpha 1010.1.0 (amd64-usr)",
+		"coreos beta 1100.0.0 (arm64-usr)",
+		"flatcar beta 3200.0.0 (amd64-usr)",
+		"flatcar stable 3000.0.0 (amd64-usr)",
+		"twin stable 3100.0.0 (amd64-usr)",
+	}
+	if got := imageStrings(s.Images()); !reflect.DeepEqual(got, want) {
+		t.Errorf("migrated %v, expected %v", got, want)
+	}
+	for _, img := range s.Images() {
+		p, _ := LookupProvider(img.Distro)
+		if img.Source != SourceMigrated || img.Verified() != Unverified || !img.bootable(p) {
+			t.Errorf("%s: migrated as %+v", img, img)
+		}
+	}
+	if _, err := os.Stat(s.indexPath()); err != nil {
+		t.Errorf("index wasn't saved: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package coreos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+// quarantineDirectory holds the files of images that failed verification,
+// inside the image directory.
+const quarantineDirectory = ".quarantine"
+
+// FileStatus is the outcome of re-checking a stored file.
+type FileStatus string
+
+const (
+	// FileOK files match their recorded digests and signature.
+	FileOK FileStatus = "ok"
+	// FileMissing files have been deleted from the image directory.
+	FileMissing FileStatus = "missing"
+	// FileCorrupt files don't match their recorded size or digests.
+	FileCorrupt FileStatus = "corrupt"
+	// FileBadSignature files don't match their detached signature.
+	FileBadSignature FileStatus = "bad signature"
+	// FileUnchecked files have a signature that couldn't be checked,
+	// because no keys are trusted, but match their digests.
+	FileUnchecked FileStatus = "unchecked"
+)
+
+// FileReport is the outcome of re-checking one file of an image.
+type FileReport struct {
+	Name   string     `json:"name"`
+	Path   string     `json:"path"`
+	Status FileStatus `json:"status"`
+	// Signature is whether a detached signature was checked.
+	Signature bool `json:"signature"`
+	// Error explains a status other than FileOK.
+	Error string `json:"error,omitempty"`
+}
+
+// Failed reports whether the file can't be trusted.
+func (r FileReport) Failed() bool {
+	return r.Status != FileOK && r.Status != FileUnchecked
+}
+
+// VerifyReport is the outcome of re-checking every file of an image.
+type VerifyReport struct {
+	Image Image        `json:"image"`
+	Files []FileReport `json:"files"`
+	// Quarantined is where the image's files were moved, if they were.
+	Quarantined string `json:"quarantined,omitempty"`
+}
+
+// Failed reports whether any file of the image can't be trusted.
+func (r VerifyReport) Failed() bool {
+	for _, f := range r.Files {
+		if f.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify re-checks the files of img against the size and digests recorded
+// when they were added, and their detached signatures against keyring. An
+// error is only returned if the checks couldn't be made, failed checks are
+// in the report.
+func (s *Store) Verify(img Image, keyring *Keyring) (VerifyReport, error) {
+	report := VerifyReport{Image: img}
+	for _, f := range img.Files {
+		r, err := s.verifyFile(f, keyring)
+		if err != nil {
+			return report, err
+		}
+		report.Files = append(report.Files, r)
+	}
+	return report, nil
+}
+
+func (s *Store) verifyFile(f ImageFile, keyring *Keyring) (FileReport, error) {
+	r := FileReport{Name: f.Name, Path: f.Path, Status: FileOK}
+	loc := s.Path(f.Path)
+	fi, err := os.Stat(loc)
+	if os.IsNotExist(err) {
+		r.Status, r.Error = FileMissing, err.Error()
+		return r, nil
+	}
+	if err != nil {
+		return r, err
+	}
+	if fi.Size() != f.Size {
+		r.Status = FileCorrupt
+		r.Error = fmt.Sprintf("size is %d bytes, expected %d", fi.Size(), f.Size)
+		return r, nil
+	}
+	actual, err := DigestFile(loc)
+	if err != nil {
+		return r, err
+	}
+	if err := f.Digests.Check(loc, actual); err != nil {
+		r.Status, r.Error = FileCorrupt, err.Error()
+		return r, nil
+	}
+
+	if f.Signature == "" {
+		return r, nil
+	}
+	r.Signature = true
+	err = verify(keyring, loc, s.Path(f.Signature))
+	var sigErr *SignatureError
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrNoTrustedKeys):
+		r.Status, r.Error = FileUnchecked, err.Error()
+	case errors.As(err, &sigErr):
+		r.Status, r.Error = FileBadSignature, err.Error()
+	default:
+		return r, err
+	}
+	return r, nil
+}
+
+// Quarantine moves the files of img, with their signatures and recorded
+// digests, out of the way into a directory of their own and removes img from
+// the index, so it's no longer booted. The index record is saved alongside
+// them as image.json. It returns the directory the files were moved to. It
+// fails with ErrImageInUse if a VM is using img.
+func (s *Store) Quarantine(img Image) (string, error) {
+	lock, err := s.lockExclusive(img)
+	if err != nil {
+		return "", err
+	}
+	defer lock.Unlock()
+
+	parent := path.Join(s.Directory, quarantineDirectory)
+	if err := os.MkdirAll(parent, 0700); err != nil {
+		return "", err
+	}
+	channel, version := img.ref()
+	dir, err := ioutil.TempDir(parent, imageName(channel, img.Board, version)+"."+time.Now().UTC().Format("20060102T150405Z")+".")
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(img, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "image.json"), data, 0644); err != nil {
+		return "", err
+	}
+	if err := s.Delete(img); err != nil {
+		return dir, err
+	}
+	var errs Errors
+	for _, f := range img.Files {
+		loc := s.Path(f.Path)
+		for _, name := range []string{loc, s.Path(f.Signature), loc + digestsSuffix} {
+			if name == "" {
+				continue
+			}
+			if err := os.Rename(name, path.Join(dir, path.Base(name))); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return dir, errs
+	}
+	return dir, nil
+}
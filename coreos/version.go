@@ -0,0 +1,89 @@
+package coreos
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is a CoreOS version number: major.minor.patch, where major is the
+// build, minor the branch and patch the patch level. Anything after the patch
+// level, such as "+build", is kept in Extra.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+	Extra string
+}
+
+// versionExtra is what may follow the patch level. Versions end up in file
+// names, so it can't contain a path separator.
+var versionExtra = regexp.MustCompile(`^[-+][0-9A-Za-z.+-]*$`)
+
+// ParseVersion parses a version number. Missing minor or patch levels are
+// taken to be zero.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	rest := s
+	if i := strings.IndexAny(rest, "+-"); i >= 0 {
+		rest, v.Extra = rest[:i], rest[i:]
+		if !versionExtra.MatchString(v.Extra) {
+			return Version{}, fmt.Errorf("invalid version %q", s)
+		}
+	}
+	parts := strings.Split(rest, ".")
+	if len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid version %q", s)
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("invalid version %q", s)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d%s", v.Major, v.Minor, v.Patch, v.Extra)
+}
+
+// Compare returns -1, 0 or 1 if v is older than, the same as or newer than o.
+func (v Version) Compare(o Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		switch {
+		case d < 0:
+			return -1
+		case d > 0:
+			return 1
+		}
+	}
+	return strings.Compare(v.Extra, o.Extra)
+}
+
+// CompareVersions compares two version strings like Version.Compare. Strings
+// that aren't valid versions sort before those that are.
+func CompareVersions(a, b string) int {
+	va, erra := ParseVersion(a)
+	vb, errb := ParseVersion(b)
+	switch {
+	case erra != nil && errb != nil:
+		return strings.Compare(a, b)
+	case erra != nil:
+		return -1
+	case errb != nil:
+		return 1
+	}
+	return va.Compare(vb)
+}
+
+// SortVersions sorts version strings from oldest to newest.
+func SortVersions(versions []string) {
+	sort.Slice(versions, func(i, j int) bool {
+		return CompareVersions(versions[i], versions[j]) < 0
+	})
+}
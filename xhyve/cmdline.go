@@ -0,0 +1,235 @@
+package xhyve
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// DefaultCmdlineSize is the longest command line an x86 kernel accepts when
+// its boot header doesn't say.
+const DefaultCmdlineSize = 2048
+
+// ErrCmdlineComma is returned for a command line containing a comma, which
+// xhyve would take as the end of its kexec firmware argument.
+var ErrCmdlineComma = errors.New("kernel command line can't contain a comma, xhyve's kexec argument is comma separated")
+
+// cmdlineEnd separates the kernel's parameters from those given to init.
+const cmdlineEnd = "--"
+
+// Param is a kernel parameter, either a flag or a key with a value.
+type Param struct {
+	Key   string
+	Value string
+	// HasValue distinguishes key= from the flag key.
+	HasValue bool
+}
+
+// String formats p for the kernel, which takes everything between double
+// quotes as it is, with no escapes. Values containing a double quote can't be
+// written, and Validate rejects them.
+func (p Param) String() string {
+	if !p.HasValue {
+		return p.Key
+	}
+	if p.Value == "" || strings.IndexFunc(p.Value, unicode.IsSpace) >= 0 {
+		return p.Key + `="` + p.Value + `"`
+	}
+	return p.Key + "=" + p.Value
+}
+
+// Cmdline is a kernel command line: parameters in the order the kernel sees
+// them. A key may be given more than once, as console often is.
+type Cmdline struct {
+	params []Param
+}
+
+// ParseCmdline splits s into parameters the way the kernel does: on
+// whitespace outside double quotes, which are removed from around values or
+// whole parameters.
+func ParseCmdline(s string) (Cmdline, error) {
+	var c Cmdline
+	var token strings.Builder
+	inQuote, inToken := false, false
+	flush := func() {
+		if inToken {
+			c.params = append(c.params, parseParam(token.String()))
+		}
+		token.Reset()
+		inToken = false
+	}
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			inToken = true
+			token.WriteRune(r)
+		case unicode.IsSpace(r) && !inQuote:
+			flush()
+		default:
+			inToken = true
+			token.WriteRune(r)
+		}
+	}
+	if inQuote {
+		return Cmdline{}, fmt.Errorf("unbalanced quotes in kernel command line %q", s)
+	}
+	flush()
+	return c, nil
+}
+
+// parseParam splits a parameter at its first =, even inside quotes as the
+// kernel does so "key=a b" is key with the value a b, and removes the quotes.
+func parseParam(s string) Param {
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		return Param{Key: unquote(s[:i]), Value: unquote(s[i+1:]), HasValue: true}
+	}
+	return Param{Key: unquote(s)}
+}
+
+func unquote(s string) string {
+	return strings.Replace(s, `"`, "", -1)
+}
+
+// Params returns the parameters of c.
+func (c Cmdline) Params() []Param {
+	return append([]Param(nil), c.params...)
+}
+
+// Get returns the value of the last parameter called key, which is the one
+// the kernel uses, and whether there is one.
+func (c Cmdline) Get(key string) (string, bool) {
+	for i := len(c.params) - 1; i >= 0; i-- {
+		if c.params[i].Key == key {
+			return c.params[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// Set replaces every parameter called key with key=value, where the first of
+// them was, or adds it if there are none.
+func (c *Cmdline) Set(key, value string) {
+	c.set(Param{Key: key, Value: value, HasValue: true})
+}
+
+// SetFlag replaces every parameter called key with the flag key, or adds it.
+func (c *Cmdline) SetFlag(key string) {
+	c.set(Param{Key: key})
+}
+
+func (c *Cmdline) set(p Param) {
+	for i, old := range c.params {
+		if old.Key == p.Key {
+			c.params[i] = p
+			c.unsetFrom(i+1, p.Key)
+			return
+		}
+	}
+	c.Add(p)
+}
+
+// Add appends p, keeping any other parameters of the same name, before the
+// parameters for init if there are any.
+func (c *Cmdline) Add(p Param) {
+	params, init := c.split()
+	c.params = append(append(params, p), init...)
+}
+
+// Unset removes every parameter called key.
+func (c *Cmdline) Unset(key string) {
+	c.unsetFrom(0, key)
+}
+
+func (c *Cmdline) unsetFrom(start int, key string) {
+	params := c.params[:start]
+	for _, p := range c.params[start:] {
+		if p.Key != key {
+			params = append(params, p)
+		}
+	}
+	c.params = params
+}
+
+// Override applies other to c, as a user's command line is applied to the
+// defaults. A parameter -key removes every parameter called key. Otherwise
+// the parameters of other replace every parameter of c with the same name,
+// so giving console twice in other leaves c with those two consoles. If other
+// has parameters for init, after --, they replace those of c.
+func (c *Cmdline) Override(other Cmdline) {
+	params, init := other.split()
+	for _, p := range params {
+		if isUnset(p) {
+			c.Unset(strings.TrimPrefix(p.Key, "-"))
+		}
+	}
+	replaced := make(map[string]bool)
+	for _, p := range params {
+		if isUnset(p) {
+			continue
+		}
+		if !replaced[p.Key] {
+			replaced[p.Key] = true
+			c.Unset(p.Key)
+		}
+		c.Add(p)
+	}
+	if init != nil {
+		own, _ := c.split()
+		c.params = append(own, init...)
+	}
+}
+
+// split returns the kernel's parameters and those for init, starting with
+// --, which is nil if there are none.
+func (c Cmdline) split() ([]Param, []Param) {
+	for i, p := range c.params {
+		if p.Key == cmdlineEnd && !p.HasValue {
+			return c.params[:i:i], c.params[i:]
+		}
+	}
+	return c.params, nil
+}
+
+func isUnset(p Param) bool {
+	return strings.HasPrefix(p.Key, "-") && len(p.Key) > 1 && !p.HasValue
+}
+
+// String formats c for the kernel, quoting values that contain spaces.
+func (c Cmdline) String() string {
+	params := make([]string, len(c.params))
+	for i, p := range c.params {
+		params[i] = p.String()
+	}
+	return strings.Join(params, " ")
+}
+
+// Validate checks that c can be passed to a kernel that accepts command lines
+// up to maxSize bytes long through xhyve's kexec argument.
+func (c Cmdline) Validate(maxSize int) error {
+	for _, p := range c.params {
+		switch {
+		case p.Key == "":
+			return fmt.Errorf("kernel parameter %q has no name", p.String())
+		case strings.IndexFunc(p.Key, unicode.IsSpace) >= 0:
+			return fmt.Errorf("kernel parameter name %q contains a space", p.Key)
+		case strings.ContainsRune(p.Key+p.Value, '"'):
+			return fmt.Errorf("kernel parameter %s can't contain a double quote", p.Key)
+		case strings.IndexFunc(p.Key+p.Value, isControl) >= 0:
+			return fmt.Errorf("kernel parameter %s contains a control character", p.Key)
+		}
+	}
+	s := c.String()
+	if strings.Contains(s, ",") {
+		return fmt.Errorf("%w: %q", ErrCmdlineComma, s)
+	}
+	if len(s) > maxSize {
+		return fmt.Errorf("kernel command line is %d bytes, longer than the %d the kernel accepts", len(s), maxSize)
+	}
+	return nil
+}
+
+func isControl(r rune) bool {
+	return unicode.IsControl(r) && r != ' '
+}
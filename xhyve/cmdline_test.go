@@ -0,0 +1,195 @@
+package xhyve
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCmdline(t *testing.T) {
+	tests := []struct {
+		cmdline string
+		want    []Param
+		// str is what String gives, the same as cmdline if empty
+		str string
+		err bool
+	}{
+		{
+			cmdline: "earlyprintk=serial console=ttyS0 coreos.autologin",
+			want: []Param{
+				{Key: "earlyprintk", Value: "serial", HasValue: true},
+				{Key: "console", Value: "ttyS0", HasValue: true},
+				{Key: "coreos.autologin"},
+			},
+		},
+		{
+			cmdline: "  a=1 \t b\n",
+			want:    []Param{{Key: "a", Value: "1", HasValue: true}, {Key: "b"}},
+			str:     "a=1 b",
+		},
+		{
+			cmdline: `sshkey="ssh-rsa AAAAB3NzaC1yc2E user@host"`,
+			want:    []Param{{Key: "sshkey", Value: "ssh-rsa AAAAB3NzaC1yc2E user@host", HasValue: true}},
+		},
+		{
+			cmdline: `"sshkey=ssh-ed25519 AAAAC3 user@host" x`,
+			want:    []Param{{Key: "sshkey", Value: "ssh-ed25519 AAAAC3 user@host", HasValue: true}, {Key: "x"}},
+			str:     `sshkey="ssh-ed25519 AAAAC3 user@host" x`,
+		},
+		{
+			cmdline: `empty= quoted=""`,
+			want:    []Param{{Key: "empty", HasValue: true}, {Key: "quoted", HasValue: true}},
+			str:     `empty="" quoted=""`,
+		},
+		{
+			cmdline: `opt="a b"c d="x=y"`,
+			want:    []Param{{Key: "opt", Value: "a bc", HasValue: true}, {Key: "d", Value: "x=y", HasValue: true}},
+			str:     `opt="a bc" d=x=y`,
+		},
+		{
+			cmdline: "root=/dev/vda -- single init=/bin/sh",
+			want: []Param{
+				{Key: "root", Value: "/dev/vda", HasValue: true},
+				{Key: "--"},
+				{Key: "single"},
+				{Key: "init", Value: "/bin/sh", HasValue: true},
+			},
+		},
+		{cmdline: "", want: nil},
+		{cmdline: `sshkey="ssh-rsa AAAA`, err: true},
+		{cmdline: `a="b" c"`, err: true},
+	}
+	for _, tt := range tests {
+		c, err := ParseCmdline(tt.cmdline)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseCmdline(%q) = %v, expected an error", tt.cmdline, c.Params())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCmdline(%q) failed: %v", tt.cmdline, err)
+			continue
+		}
+		if !reflect.DeepEqual(c.Params(), tt.want) {
+			t.Errorf("ParseCmdline(%q) = %#v, expected %#v", tt.cmdline, c.Params(), tt.want)
+		}
+		str := tt.str
+		if str == "" {
+			str = tt.cmdline
+		}
+		if c.String() != str {
+			t.Errorf("ParseCmdline(%q).String() = %q, expected %q", tt.cmdline, c.String(), str)
+		}
+		// what String gives parses back to the same parameters
+		again, err := ParseCmdline(c.String())
+		if err != nil || !reflect.DeepEqual(again.Params(), c.Params()) {
+			t.Errorf("%q parsed back to %#v, %v, expected %#v", c.String(), again.Params(), err, c.Params())
+		}
+	}
+}
+
+func TestCmdlineOverride(t *testing.T) {
+	const defaults = "earlyprintk=serial console=ttyS0 coreos.autologin"
+	tests := []struct {
+		defaults string
+		user     string
+		want     string
+	}{
+		{defaults, "", defaults},
+		{defaults, "console=tty0", "earlyprintk=serial coreos.autologin console=tty0"},
+		{defaults, "console=tty0 console=ttyS1", "earlyprintk=serial coreos.autologin console=tty0 console=ttyS1"},
+		{defaults, "-coreos.autologin", "earlyprintk=serial console=ttyS0"},
+		{defaults, "-console console=tty1", "earlyprintk=serial coreos.autologin console=tty1"},
+		{defaults, "console=tty1 -console", "earlyprintk=serial coreos.autologin console=tty1"},
+		{defaults, "-missing", defaults},
+		{defaults, `sshkey="ssh-rsa AAAA user@host"`, defaults + ` sshkey="ssh-rsa AAAA user@host"`},
+		{defaults, "coreos.autologin=tty1", "earlyprintk=serial console=ttyS0 coreos.autologin=tty1"},
+		{defaults, "-- single", defaults + " -- single"},
+		{"a=1 -- x", "b=2", "a=1 b=2 -- x"},
+		{"a=1 -- x", "a=2 -- y", "a=2 -- y"},
+		{"console=ttyS0 a console=tty0", "console=hvc0", "a console=hvc0"},
+	}
+	for _, tt := range tests {
+		c, err := ParseCmdline(tt.defaults)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, err := ParseCmdline(tt.user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Override(user)
+		if c.String() != tt.want {
+			t.Errorf("%q overridden by %q is %q, expected %q", tt.defaults, tt.user, c.String(), tt.want)
+		}
+	}
+}
+
+func TestCmdlineSet(t *testing.T) {
+	c, err := ParseCmdline("console=ttyS0 a console=tty0 -- single")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set("console", "hvc0")
+	c.Set("sshkey", "ssh-rsa AAAA user@host")
+	c.SetFlag("coreos.autologin")
+	want := `console=hvc0 a sshkey="ssh-rsa AAAA user@host" coreos.autologin -- single`
+	if c.String() != want {
+		t.Errorf("got %q, expected %q", c.String(), want)
+	}
+	if v, ok := c.Get("sshkey"); !ok || v != "ssh-rsa AAAA user@host" {
+		t.Errorf("sshkey is %q, %t", v, ok)
+	}
+}
+
+func TestCmdlineValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmdline string
+		// set is a parameter to set after parsing, for values that
+		// can't be parsed
+		set     []string
+		maxSize int
+		// want is an error the result should wrap, nil for any error
+		want error
+		bad  bool
+	}{
+		{name: "defaults", cmdline: "earlyprintk=serial console=ttyS0 coreos.autologin", maxSize: DefaultCmdlineSize},
+		{name: "quoted", cmdline: `sshkey="ssh-rsa AAAA user@host"`, maxSize: DefaultCmdlineSize},
+		{name: "fits", cmdline: strings.Repeat("a", 2048), maxSize: 2048},
+		{name: "too long", cmdline: strings.Repeat("a", 2049), maxSize: 2048, bad: true},
+		{name: "too long quoted", cmdline: `k="` + strings.Repeat("a ", 10) + `"`, maxSize: 20, bad: true},
+		{name: "comma", cmdline: "console=ttyS0,115200n8", maxSize: DefaultCmdlineSize, want: ErrCmdlineComma, bad: true},
+		{name: "quoted comma", cmdline: `sshkey="ssh-rsa AAAA a,b"`, maxSize: DefaultCmdlineSize, want: ErrCmdlineComma, bad: true},
+		{name: "comma flag", cmdline: "a,b", maxSize: DefaultCmdlineSize, want: ErrCmdlineComma, bad: true},
+		{name: "no name", cmdline: "=x", maxSize: DefaultCmdlineSize, bad: true},
+		{name: "space in name", cmdline: `"a b=c"`, maxSize: DefaultCmdlineSize, bad: true},
+		{name: "double quote", set: []string{"k", `a"b`}, maxSize: DefaultCmdlineSize, bad: true},
+		{name: "control character", set: []string{"k", "a\x00b"}, maxSize: DefaultCmdlineSize, bad: true},
+	}
+	for _, tt := range tests {
+		c, err := ParseCmdline(tt.cmdline)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if tt.set != nil {
+			c.Set(tt.set[0], tt.set[1])
+		}
+		err = c.Validate(tt.maxSize)
+		if !tt.bad {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+			continue
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, expected %v", tt.name, err, tt.want)
+		}
+	}
+}
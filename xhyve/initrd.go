@@ -0,0 +1,392 @@
+package xhyve
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ErrNotCpio is returned when an initrd isn't a newc cpio archive.
+var ErrNotCpio = errors.New("not a newc cpio archive")
+
+// InitrdFile is one entry of an initrd.
+type InitrdFile struct {
+	Name string      `json:"name"`
+	Mode os.FileMode `json:"mode"`
+	Size int64       `json:"size"`
+	// Link is the target of a symlink.
+	Link string `json:"link,omitempty"`
+}
+
+// InitrdInfo describes the contents of an initrd.
+type InitrdInfo struct {
+	// Size is the size of the compressed initrd, and UnpackedSize the
+	// total size of the files in it.
+	Size         int64        `json:"size"`
+	UnpackedSize int64        `json:"unpacked_size"`
+	Files        []InitrdFile `json:"files"`
+	// OSRelease is the os-release in the initrd, if there is one.
+	OSRelease map[string]string `json:"os_release,omitempty"`
+}
+
+// osReleaseFiles are where os-release may be in an initrd, in the order
+// they're preferred.
+var osReleaseFiles = []string{"etc/os-release", "usr/lib/os-release"}
+
+// maxOSReleaseSize is the largest os-release that is read.
+const maxOSReleaseSize = 64 * 1024
+
+// maxPathSize is the longest name or symlink target an entry may have,
+// PATH_MAX on Linux.
+const maxPathSize = 4096
+
+// cpio newc header fields, each 8 hex digits after the 6 byte magic.
+const (
+	cpioMagicSize  = 6
+	cpioFieldSize  = 8
+	cpioHeaderSize = cpioMagicSize + 13*cpioFieldSize
+	cpioMode       = 1
+	cpioFileSize   = 6
+	cpioNameSize   = 11
+	cpioTrailer    = "TRAILER!!!"
+)
+
+// InspectInitrd lists the files in the gzip compressed cpio initrd name.
+// It's decompressed as it's read, nothing is written to disk. Archives
+// concatenated before compression are read one after another. Initrds with
+// early microcode, an uncompressed cpio archive in front of the compressed
+// one, aren't supported.
+func InspectInitrd(name string) (InitrdInfo, error) {
+	var info InitrdInfo
+	f, err := os.Open(name)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return info, err
+	}
+	info.Size = fi.Size()
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return info, fmt.Errorf("%s: %v", name, err)
+	}
+	defer gz.Close()
+
+	r := &cpioReader{r: bufio.NewReader(gz)}
+	osRelease := make(map[string][]byte)
+	links := make(map[string]string)
+	// sizes are the sizes of the regular files, which os-release may link to
+	sizes := make(map[string]int64)
+	for {
+		file, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return info, fmt.Errorf("%s: %w", name, err)
+		}
+		info.Files = append(info.Files, file)
+		info.UnpackedSize += file.Size
+		switch {
+		case file.Mode&os.ModeSymlink != 0:
+			target, err := r.readAll(file.Size, maxPathSize)
+			if err != nil {
+				return info, fmt.Errorf("%s: %w", name, err)
+			}
+			file.Link = string(target)
+			info.Files[len(info.Files)-1] = file
+			links[file.Name] = file.Link
+		case file.Mode.IsRegular():
+			sizes[file.Name] = file.Size
+			if !isOSRelease(file.Name) || file.Size > maxOSReleaseSize {
+				break
+			}
+			data, err := r.readAll(file.Size, maxOSReleaseSize)
+			if err != nil {
+				return info, fmt.Errorf("%s: %w", name, err)
+			}
+			osRelease[file.Name] = data
+		}
+	}
+	for _, file := range osReleaseFiles {
+		target := resolveLinks(links, file)
+		size, ok := sizes[target]
+		if !ok || size > maxOSReleaseSize {
+			continue
+		}
+		data, ok := osRelease[target]
+		if !ok {
+			// a link to a file that wasn't known to be os-release when
+			// it was passed, such as dracut's initrd-release
+			if data, err = readInitrdFile(name, target); err != nil {
+				return info, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		info.OSRelease = ParseOSRelease(data)
+		break
+	}
+	return info, nil
+}
+
+// maxLinks is how many symlinks are followed resolving a name, as many as
+// Linux follows.
+const maxLinks = 40
+
+// resolveLinks follows the symlinks in links from name, returning the name
+// it ends up at.
+func resolveLinks(links map[string]string, name string) string {
+	for i := 0; i < maxLinks; i++ {
+		target, ok := links[name]
+		if !ok {
+			break
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(name), target)
+		}
+		name = strings.TrimPrefix(path.Clean(target), "/")
+	}
+	return name
+}
+
+// readInitrdFile reads the regular file named file out of the initrd name.
+func readInitrdFile(name, file string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	r := &cpioReader{r: bufio.NewReader(gz)}
+	for {
+		entry, err := r.next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s: %w", file, os.ErrNotExist)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry.Name == file && entry.Mode.IsRegular() {
+			return r.readAll(entry.Size, maxOSReleaseSize)
+		}
+	}
+}
+
+// CheckInitrd checks that the file name looks like a gzip compressed cpio
+// initrd, reading only as far as the first cpio header.
+func CheckInitrd(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("%s isn't gzip compressed: %v", name, err)
+	}
+	defer gz.Close()
+	magic := make([]byte, cpioMagicSize)
+	if _, err := io.ReadFull(gz, magic); err != nil {
+		return fmt.Errorf("%s: %v", name, unexpected(err))
+	}
+	if m := string(magic); m != "070701" && m != "070702" {
+		return fmt.Errorf("%s: %w", name, ErrNotCpio)
+	}
+	return nil
+}
+
+func isOSRelease(name string) bool {
+	for _, n := range osReleaseFiles {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseOSRelease parses the variables of an os-release file.
+func ParseOSRelease(data []byte) map[string]string {
+	vars := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			continue
+		}
+		value := line[i+1:]
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+		vars[line[:i]] = value
+	}
+	return vars
+}
+
+// cpioReader reads the entries of newc cpio archives.
+type cpioReader struct {
+	r *bufio.Reader
+	// unread is what's left of the current entry's data and padding.
+	unread int64
+}
+
+// next skips the rest of the current entry and reads the next header. The
+// trailers of concatenated archives, and the padding after them, are
+// skipped.
+func (c *cpioReader) next() (InitrdFile, error) {
+	var file InitrdFile
+	for {
+		if err := c.skip(c.unread); err != nil {
+			return file, err
+		}
+		c.unread = 0
+		// archives may be padded with NULs between them
+		for {
+			b, err := c.r.Peek(1)
+			if err != nil {
+				return file, err
+			}
+			if b[0] != 0 {
+				break
+			}
+			c.r.ReadByte()
+		}
+
+		header := make([]byte, cpioHeaderSize)
+		if _, err := io.ReadFull(c.r, header); err != nil {
+			return file, unexpected(err)
+		}
+		magic := string(header[:cpioMagicSize])
+		if magic != "070701" && magic != "070702" {
+			return file, ErrNotCpio
+		}
+		field := func(i int) (int64, error) {
+			start := cpioMagicSize + i*cpioFieldSize
+			return strconv.ParseInt(string(header[start:start+cpioFieldSize]), 16, 64)
+		}
+		mode, err := field(cpioMode)
+		if err != nil {
+			return file, ErrNotCpio
+		}
+		size, err := field(cpioFileSize)
+		if err != nil {
+			return file, ErrNotCpio
+		}
+		nameSize, err := field(cpioNameSize)
+		if err != nil || nameSize < 1 {
+			return file, ErrNotCpio
+		}
+		if nameSize > maxPathSize {
+			return file, fmt.Errorf("%w: entry name is %d bytes long", ErrNotCpio, nameSize)
+		}
+		name, err := readFull(c.r, nameSize)
+		if err != nil {
+			return file, err
+		}
+		if err := c.skip(pad4(cpioHeaderSize + nameSize)); err != nil {
+			return file, err
+		}
+		c.unread = size + pad4(size)
+
+		if string(bytes.TrimRight(name, "\x00")) == cpioTrailer {
+			continue
+		}
+		file.Name = strings.TrimPrefix(path.Clean("/"+string(bytes.TrimRight(name, "\x00"))), "/")
+		if file.Name == "" {
+			file.Name = "."
+		}
+		file.Mode = fileMode(mode)
+		file.Size = size
+		return file, nil
+	}
+}
+
+// readAll reads the data of the current entry, which is size long, if it's
+// no longer than limit.
+func (c *cpioReader) readAll(size, limit int64) ([]byte, error) {
+	if size > limit {
+		return nil, fmt.Errorf("%d bytes long, more than the %d expected", size, limit)
+	}
+	data, err := readFull(c.r, size)
+	if err != nil {
+		return nil, err
+	}
+	c.unread -= size
+	return data, nil
+}
+
+// readFull reads n bytes from r. The buffer only grows as data arrives, so a
+// size from a corrupt header can't make it allocate more than r holds.
+func readFull(r io.Reader, n int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, n))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+func (c *cpioReader) skip(n int64) error {
+	_, err := io.CopyN(ioutil.Discard, c.r, n)
+	return unexpected(err)
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func pad4(n int64) int64 {
+	return (4 - n%4) % 4
+}
+
+// fileMode converts a unix st_mode to an os.FileMode.
+func fileMode(mode int64) os.FileMode {
+	m := os.FileMode(mode & 0777)
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	switch mode & 0170000 {
+	case 0040000:
+		m |= os.ModeDir
+	case 0120000:
+		m |= os.ModeSymlink
+	case 0020000:
+		m |= os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		m |= os.ModeDevice
+	case 0010000:
+		m |= os.ModeNamedPipe
+	case 0140000:
+		m |= os.ModeSocket
+	}
+	return m
+}
@@ -0,0 +1,285 @@
+package xhyve
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// cpioEntry is an entry of a test archive. size and nameSize override the
+// sizes in the header when they're set, as a corrupt archive might have, and
+// a nameSize of -1 is written as 0.
+type cpioEntry struct {
+	name     string
+	mode     int64
+	data     string
+	size     int64
+	nameSize int64
+}
+
+// newc builds a newc cpio archive of entries, ending with a trailer.
+func newc(entries ...cpioEntry) []byte {
+	var buf bytes.Buffer
+	entries = append(entries, cpioEntry{name: cpioTrailer})
+	for i, e := range entries {
+		size := int64(len(e.data))
+		if e.size != 0 {
+			size = e.size
+		}
+		nameSize := int64(len(e.name) + 1)
+		if e.nameSize < 0 {
+			nameSize = 0
+		} else if e.nameSize != 0 {
+			nameSize = e.nameSize
+		}
+		fields := []int64{int64(i + 1), e.mode, 0, 0, 1, 0, size, 0, 0, 0, 0, nameSize, 0}
+		buf.WriteString("070701")
+		for _, f := range fields {
+			fmt.Fprintf(&buf, "%08X", f)
+		}
+		buf.WriteString(e.name + "\x00")
+		buf.Write(make([]byte, pad4(int64(cpioHeaderSize+len(e.name)+1))))
+		buf.WriteString(e.data)
+		buf.Write(make([]byte, pad4(int64(len(e.data)))))
+	}
+	return buf.Bytes()
+}
+
+func writeInitrd(t *testing.T, dir string, archives ...[]byte) string {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	for _, a := range archives {
+		gz.Write(a)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	name := path.Join(dir, "initrd.cpio.gz")
+	if err := ioutil.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+const (
+	modeDir     = 0040755
+	modeFile    = 0100644
+	modeSymlink = 0120777
+)
+
+func TestInspectInitrd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "core-initrd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	osRelease := "NAME=\"Container Linux by CoreOS\"\nID=coreos\nVERSION='1010.5.0'\n# comment\n"
+	// archives concatenated before compression, as an initrd extended by
+	// appending to it is
+	firmware := newc(
+		cpioEntry{name: "lib", mode: modeDir},
+		cpioEntry{name: "lib/firmware/fw.bin", mode: modeFile, data: "fwfw!"},
+	)
+	main := newc(
+		cpioEntry{name: ".", mode: modeDir},
+		cpioEntry{name: "etc", mode: modeDir},
+		cpioEntry{name: "etc/os-release", mode: modeSymlink, data: "../usr/lib/os-release"},
+		cpioEntry{name: "usr/lib/os-release", mode: modeFile, data: osRelease},
+		cpioEntry{name: "./init", mode: 0100755, data: "#!/bin/sh\n"},
+	)
+	// archives are padded to 512 bytes between them
+	firmware = append(firmware, make([]byte, 512-len(firmware)%512)...)
+	info, err := InspectInitrd(writeInitrd(t, dir, firmware, main))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []InitrdFile{
+		{Name: "lib", Mode: os.ModeDir | 0755},
+		{Name: "lib/firmware/fw.bin", Mode: 0644, Size: 5},
+		{Name: ".", Mode: os.ModeDir | 0755},
+		{Name: "etc", Mode: os.ModeDir | 0755},
+		{Name: "etc/os-release", Mode: os.ModeSymlink | 0777, Size: 21, Link: "../usr/lib/os-release"},
+		{Name: "usr/lib/os-release", Mode: 0644, Size: int64(len(osRelease))},
+		{Name: "init", Mode: 0755, Size: 10},
+	}
+	if !reflect.DeepEqual(info.Files, want) {
+		t.Errorf("files are\n%v\nexpected\n%v", info.Files, want)
+	}
+	if info.UnpackedSize != int64(5+21+len(osRelease)+10) {
+		t.Errorf("unpacked size is %d", info.UnpackedSize)
+	}
+	wantRelease := map[string]string{"NAME": "Container Linux by CoreOS", "ID": "coreos", "VERSION": "1010.5.0"}
+	if !reflect.DeepEqual(info.OSRelease, wantRelease) {
+		t.Errorf("os-release is %v, expected %v", info.OSRelease, wantRelease)
+	}
+}
+
+func TestInspectInitrdReleaseLink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "core-initrd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	initrdRelease := cpioEntry{name: "etc/initrd-release", mode: modeFile, data: "ID=flatcar\nVERSION_ID=3227.2.0\n"}
+	want := map[string]string{"ID": "flatcar", "VERSION_ID": "3227.2.0"}
+	tests := []struct {
+		name    string
+		entries []cpioEntry
+		want    map[string]string
+	}{
+		{
+			// dracut's layout
+			name: "link first",
+			entries: []cpioEntry{
+				{name: "etc/os-release", mode: modeSymlink, data: "initrd-release"},
+				initrdRelease,
+			},
+			want: want,
+		},
+		{
+			name: "target first",
+			entries: []cpioEntry{
+				initrdRelease,
+				{name: "etc/os-release", mode: modeSymlink, data: "initrd-release"},
+			},
+			want: want,
+		},
+		{
+			name: "absolute chain",
+			entries: []cpioEntry{
+				{name: "etc/os-release", mode: modeSymlink, data: "/usr/lib/os-release"},
+				{name: "usr/lib/os-release", mode: modeSymlink, data: "../../etc/initrd-release"},
+				initrdRelease,
+			},
+			want: want,
+		},
+		{
+			name: "dangling",
+			entries: []cpioEntry{
+				{name: "etc/os-release", mode: modeSymlink, data: "initrd-release"},
+				{name: "usr/lib/os-release", mode: modeFile, data: "ID=coreos\n"},
+			},
+			want: map[string]string{"ID": "coreos"},
+		},
+		{
+			name: "loop",
+			entries: []cpioEntry{
+				{name: "etc/os-release", mode: modeSymlink, data: "initrd-release"},
+				{name: "etc/initrd-release", mode: modeSymlink, data: "os-release"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		info, err := InspectInitrd(writeInitrd(t, dir, newc(tt.entries...)))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(info.OSRelease, tt.want) {
+			t.Errorf("%s: os-release is %v, expected %v", tt.name, info.OSRelease, tt.want)
+		}
+	}
+}
+
+func TestInspectInitrdCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "core-initrd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := newc(cpioEntry{name: "init", mode: modeFile, data: "#!/bin/sh\n"})
+	tests := []struct {
+		name    string
+		archive []byte
+		// want is an error the result should wrap, nil for any error
+		want error
+	}{
+		{
+			name:    "not cpio",
+			archive: []byte("this isn't a cpio archive, but it's long enough to have a header in it" + string(make([]byte, 64))),
+			want:    ErrNotCpio,
+		},
+		{
+			name:    "bad field",
+			archive: bytes.Replace(valid, []byte("070701"), []byte("070701ZZ"), 1)[:len(valid)],
+			want:    ErrNotCpio,
+		},
+		{
+			name:    "truncated header",
+			archive: valid[:cpioHeaderSize/2],
+			want:    io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "truncated data",
+			archive: valid[:len(valid)-cpioHeaderSize-len(cpioTrailer)-8],
+			want:    io.ErrUnexpectedEOF,
+		},
+		{
+			// the header claims 4GB of symlink target, which mustn't be
+			// allocated
+			name:    "huge symlink",
+			archive: newc(cpioEntry{name: "etc/os-release", mode: modeSymlink, data: "x", size: 0xffffffff}),
+		},
+		{
+			name:    "short symlink",
+			archive: newc(cpioEntry{name: "etc/os-release", mode: modeSymlink, data: "x", size: 4000})[:cpioHeaderSize+20],
+			want:    io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "huge name",
+			archive: newc(cpioEntry{name: "init", mode: modeFile, nameSize: 0xffffffff}),
+			want:    ErrNotCpio,
+		},
+		{
+			name:    "no name",
+			archive: newc(cpioEntry{name: "init", mode: modeFile, nameSize: -1}),
+			want:    ErrNotCpio,
+		},
+		{
+			name:    "short os-release",
+			archive: newc(cpioEntry{name: "etc/os-release", mode: modeFile, data: "ID=coreos\n", size: 1000})[:cpioHeaderSize+30],
+			want:    io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tt := range tests {
+		_, err := InspectInitrd(writeInitrd(t, dir, tt.archive))
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+			continue
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, expected %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestCheckInitrd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "core-initrd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := CheckInitrd(writeInitrd(t, dir, newc())); err != nil {
+		t.Errorf("valid initrd: %v", err)
+	}
+	if err := CheckInitrd(writeInitrd(t, dir, []byte("#!/bin/sh\n"))); !errors.Is(err, ErrNotCpio) {
+		t.Errorf("got %v, expected %v", err, ErrNotCpio)
+	}
+	name := path.Join(dir, "initrd")
+	ioutil.WriteFile(name, newc(), 0644)
+	if err := CheckInitrd(name); err == nil {
+		t.Errorf("uncompressed initrd: expected an error")
+	}
+}
@@ -0,0 +1,151 @@
+package xhyve
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Arch is the architecture of the kernels xhyve can boot, in the form used
+// by GOARCH.
+const Arch = "amd64"
+
+var (
+	// bzImageMagic is at bzImageMagicOffset in an x86 boot image.
+	bzImageMagic       = []byte("HdrS")
+	bzImageMagicOffset = 0x202
+	// arm64Magic is at arm64MagicOffset in an arm64 kernel Image.
+	arm64Magic       = []byte("ARM\x64")
+	arm64MagicOffset = 56
+)
+
+// KernelArch guesses the architecture of the kernel at path from its header.
+// It returns amd64 for an x86 boot image, arm64 for an arm64 Image, or an
+// empty string if it can't tell.
+func KernelArch(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	header := make([]byte, bzImageMagicOffset+len(bzImageMagic))
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	header = header[:n]
+	switch {
+	case hasMagic(header, bzImageMagicOffset, bzImageMagic):
+		return "amd64", nil
+	case hasMagic(header, arm64MagicOffset, arm64Magic):
+		return "arm64", nil
+	}
+	return "", nil
+}
+
+func hasMagic(header []byte, offset int, magic []byte) bool {
+	return len(header) >= offset+len(magic) && bytes.Equal(header[offset:offset+len(magic)], magic)
+}
+
+// ErrNotBzImage is returned when a kernel isn't an x86 boot image.
+var ErrNotBzImage = errors.New("not a bzImage")
+
+// KernelHeader is the part of an x86 boot image's setup header that says
+// what the kernel is, see Documentation/x86/boot.txt in the Linux tree.
+type KernelHeader struct {
+	// Version is the kernel version string, such as
+	// "4.5.0-coreos (jenkins@worker) #2 SMP ...".
+	Version string `json:"version"`
+	// Protocol is the boot protocol version, such as 2.13.
+	Protocol string `json:"protocol"`
+	// SetupSize is the size of the real mode setup code, and KernelSize
+	// the size of the protected mode kernel after it.
+	SetupSize  int64 `json:"setup_size"`
+	KernelSize int64 `json:"kernel_size"`
+	// PayloadSize is the size of the compressed kernel, and InitSize the
+	// memory the kernel needs to decompress and start. Both are 0 for
+	// protocols too old to report them.
+	PayloadSize int64 `json:"payload_size"`
+	InitSize    int64 `json:"init_size"`
+	// CmdlineSize is the longest command line the kernel accepts, 255 for
+	// protocols older than 2.06.
+	CmdlineSize int64 `json:"cmdline_size"`
+	Relocatable bool  `json:"relocatable"`
+}
+
+// Offsets in the setup header.
+const (
+	setupSectsOffset     = 0x1f1
+	protocolOffset       = 0x206
+	kernelVersionOffset  = 0x20e
+	relocatableOffset    = 0x234
+	cmdlineSizeOffset    = 0x238
+	payloadLengthOffset  = 0x24c
+	initSizeOffset       = 0x260
+	setupHeaderEndOffset = 0x264
+)
+
+// ReadKernelHeader reads the setup header of the x86 boot image at path.
+func ReadKernelHeader(path string) (KernelHeader, error) {
+	var h KernelHeader
+	f, err := os.Open(path)
+	if err != nil {
+		return h, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return h, err
+	}
+	header := make([]byte, setupHeaderEndOffset)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return h, err
+	}
+	if !hasMagic(header[:n], bzImageMagicOffset, bzImageMagic) {
+		return h, fmt.Errorf("%s: %w", path, ErrNotBzImage)
+	}
+
+	protocol := binary.LittleEndian.Uint16(header[protocolOffset:])
+	h.Protocol = fmt.Sprintf("%d.%02d", protocol>>8, protocol&0xff)
+	if protocol < 0x200 {
+		return h, fmt.Errorf("%s: boot protocol %s is too old", path, h.Protocol)
+	}
+	setupSects := int64(header[setupSectsOffset])
+	if setupSects == 0 {
+		setupSects = 4
+	}
+	h.SetupSize = (setupSects + 1) * 512
+	h.KernelSize = fi.Size() - h.SetupSize
+	h.CmdlineSize = 255
+	if protocol >= 0x205 {
+		h.Relocatable = header[relocatableOffset] != 0
+	}
+	if protocol >= 0x206 {
+		h.CmdlineSize = int64(binary.LittleEndian.Uint32(header[cmdlineSizeOffset:]))
+	}
+	if protocol >= 0x208 {
+		h.PayloadSize = int64(binary.LittleEndian.Uint32(header[payloadLengthOffset:]))
+	}
+	if protocol >= 0x20a {
+		h.InitSize = int64(binary.LittleEndian.Uint32(header[initSizeOffset:]))
+	}
+
+	// the version string is NUL terminated, somewhere in the setup code
+	if offset := int64(binary.LittleEndian.Uint16(header[kernelVersionOffset:])); offset != 0 {
+		version := make([]byte, 256)
+		n, err := f.ReadAt(version, offset+0x200)
+		if err != nil && err != io.EOF {
+			return h, err
+		}
+		version = version[:n]
+		if i := bytes.IndexByte(version, 0); i >= 0 {
+			version = version[:i]
+		}
+		h.Version = string(version)
+	}
+	return h, nil
+}
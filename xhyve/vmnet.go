@@ -0,0 +1,27 @@
+package xhyve
+
+import (
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// DefaultHostAddress is the host's address on the network vmnet shares with
+// VMs, unless it has been configured otherwise.
+const DefaultHostAddress = "192.168.64.1"
+
+// vmnetPreferences configure vmnet's shared network.
+const vmnetPreferences = "/Library/Preferences/SystemConfiguration/com.apple.vmnet"
+
+// HostAddress returns the host's address on the network vmnet shares with
+// VMs, which VMs can reach the host on.
+func HostAddress() string {
+	out, err := exec.Command("defaults", "read", vmnetPreferences, "Shared_Net_Address").Output()
+	if err != nil {
+		return DefaultHostAddress
+	}
+	if ip := net.ParseIP(strings.TrimSpace(string(out))); ip != nil {
+		return ip.String()
+	}
+	return DefaultHostAddress
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os/exec"
 	"strconv"
+	"strings"
 
 	"code.google.com/p/go-uuid/uuid"
 )
@@ -21,7 +22,7 @@ type Config struct {
 type KernelConfig struct {
 	Initrd  string
 	Vmlinuz string
-	Cmdline string
+	Cmdline Cmdline
 }
 
 func (cfg Config) Validate() error {
@@ -29,9 +30,42 @@ func (cfg Config) Validate() error {
 		return fmt.Errorf("Invalid UUID: %s", cfg.UUID)
 	}
 	if cfg.CPUs < 1 {
-		return fmt.Errorf("Invalid number of CPUs: %s", cfg.CPUs)
+		return fmt.Errorf("Invalid number of CPUs: %d", cfg.CPUs)
 	}
-	return nil
+	return cfg.KernelConfig.Validate()
+}
+
+// Validate checks that the kernel is an x86 boot image and the initrd a gzip
+// compressed cpio archive, before xhyve is given them, and that their paths
+// and the command line fit in the kernel's and in xhyve's kexec argument.
+func (cfg KernelConfig) Validate() error {
+	if cfg.Vmlinuz == "" || cfg.Initrd == "" {
+		return fmt.Errorf("a kernel and an initrd are required")
+	}
+	for _, name := range []string{cfg.Vmlinuz, cfg.Initrd} {
+		if strings.Contains(name, ",") {
+			return fmt.Errorf("%s: path can't contain a comma, xhyve's kexec argument is comma separated", name)
+		}
+	}
+	arch, err := KernelArch(cfg.Vmlinuz)
+	if err != nil {
+		return err
+	}
+	if arch != "" && arch != Arch {
+		return fmt.Errorf("%s is an %s kernel, xhyve can only boot %s kernels", cfg.Vmlinuz, arch, Arch)
+	}
+	header, err := ReadKernelHeader(cfg.Vmlinuz)
+	if err != nil {
+		return err
+	}
+	if err := CheckInitrd(cfg.Initrd); err != nil {
+		return err
+	}
+	maxSize := DefaultCmdlineSize
+	if header.CmdlineSize > 0 {
+		maxSize = int(header.CmdlineSize)
+	}
+	return cfg.Cmdline.Validate(maxSize)
 }
 
 func Command(cfg Config) (*exec.Cmd, error) {
@@ -64,7 +98,7 @@ func Command(cfg Config) (*exec.Cmd, error) {
 	args = append(args, cfg.Extra...)
 
 	firmware := fmt.Sprintf("kexec,%s,%s,%s",
-		cfg.KernelConfig.Vmlinuz, cfg.KernelConfig.Initrd, cfg.KernelConfig.Cmdline)
+		cfg.KernelConfig.Vmlinuz, cfg.KernelConfig.Initrd, cfg.KernelConfig.Cmdline.String())
 	args = append(args, "-f", firmware)
 
 	return exec.Command(cfg.XhyvePath, args...), nil